@@ -0,0 +1,242 @@
+// bisect searches an ordered list of Cilium builds for the first one
+// regressing in a scenario of the gke tests, compared to the first build of
+// the list. A build is an image tag shared by the agent and operator, or
+// "agent=operator" tags or digests. It only swaps the images of the agent and
+// operator of the Cilium already deployed in the cluster between steps, e.g.
+// from the gke directory:
+//
+//	go run ../cmd/bisect -scenario big-load -metric 'avg(agent_cpu_cores)' v1.9.0-rc1 v1.9.0-rc2 v1.9.0-rc3
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/bisect"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	"github.com/cilium/cilium-perf-test/internal/run"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	// auth provider for GCP, enables the client to authenticate with GKE without external
+	// dependencies (e.g. gcloud CLI)
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+)
+
+var (
+	scenario  = flag.String("scenario", "", "test case of the gke tests to run for each build, e.g. big-load")
+	metric    = flag.String("metric", "avg(agent_cpu_cores)", "result of the scenario compared across builds, higher is worse")
+	threshold = flag.Float64("threshold", 0.1, "increase of the metric, relative to the first build, above which a build regressed")
+	repeat    = flag.Int("repeat", 1, "number of times the scenario is run for each build, the median of the metric being compared")
+	namespace = flag.String("ns", "cilium-perf", "namespace that Cilium is in")
+	agent     = flag.String("agent", "cilium", "name of the agent DaemonSet")
+	operator  = flag.String("operator", "cilium-operator", "name of the operator Deployment")
+	testDir   = flag.String("dir", ".", "directory of the gke tests")
+	testArgs  = flag.String("test-args", "", "flags passed to the gke tests, e.g. \"-duration=5m -cilium-version=1.8\"")
+	out       = flag.String("out", "bisect", "directory the reports of each step are written to")
+)
+
+// images swaps the images of the agent and operator of the Cilium deployed in
+// the cluster.
+type images struct {
+	client kubernetes.Interface
+}
+
+// retag sets the tag or digest of the images of the containers of spec from
+// the repository of its first container to ref.
+func retag(spec *corev1.PodSpec, ref string) {
+	repo := bisect.Repository(spec.Containers[0].Image)
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if bisect.Repository(containers[i].Image) == repo {
+				containers[i].Image = bisect.WithRef(containers[i].Image, ref)
+			}
+		}
+	}
+}
+
+// templates returns the current pod templates of the agent and operator.
+func (im images) templates(ctx context.Context) (corev1.PodTemplateSpec, corev1.PodTemplateSpec, error) {
+	ds, err := im.client.AppsV1().DaemonSets(*namespace).Get(ctx, *agent, metav1.GetOptions{})
+	if err != nil {
+		return corev1.PodTemplateSpec{}, corev1.PodTemplateSpec{}, err
+	}
+	d, err := im.client.AppsV1().Deployments(*namespace).Get(ctx, *operator, metav1.GetOptions{})
+	if err != nil {
+		return corev1.PodTemplateSpec{}, corev1.PodTemplateSpec{}, err
+	}
+	return ds.Spec.Template, d.Spec.Template, nil
+}
+
+// update applies changeAgent and changeOperator to the pod templates of the
+// agent and operator and waits for them to be rolled out.
+func (im images) update(ctx context.Context, changeAgent, changeOperator func(*corev1.PodTemplateSpec)) error {
+	daemonSets := im.client.AppsV1().DaemonSets(*namespace)
+	ds, err := daemonSets.Get(ctx, *agent, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changeAgent(&ds.Spec.Template)
+	if _, err := daemonSets.Update(ctx, ds, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update agent: %w", err)
+	}
+
+	deployments := im.client.AppsV1().Deployments(*namespace)
+	d, err := deployments.Get(ctx, *operator, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changeOperator(&d.Spec.Template)
+	if _, err := deployments.Update(ctx, d, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update operator: %w", err)
+	}
+
+	return readiness.Wait(im.client, []readiness.Object{
+		{Kind: readiness.DaemonSet, Namespace: *namespace, Name: *agent},
+		{Kind: readiness.Deployment, Namespace: *namespace, Name: *operator},
+	}, 15*time.Minute)
+}
+
+// set rolls the agent and operator out with the images of build.
+func (im images) set(ctx context.Context, build bisect.Build) error {
+	log.Printf("Rolling out the agent and operator images %s", build)
+	return im.update(ctx,
+		func(template *corev1.PodTemplateSpec) { retag(&template.Spec, build.Agent) },
+		func(template *corev1.PodTemplateSpec) { retag(&template.Spec, build.Operator) },
+	)
+}
+
+// measure runs the scenario and returns the value of the metric.
+func measure(ctx context.Context, step int, build bisect.Build) (float64, error) {
+	name := fmt.Sprintf("%d-%s.json", step, strings.NewReplacer("/", "-", ":", "-").Replace(build.String()))
+	reportPath, err := filepath.Abs(filepath.Join(*out, name))
+	if err != nil {
+		return 0, err
+	}
+	args := []string{"test", "-v", ".", "-count=1", "-timeout=2h",
+		"-run", "TestCases/^" + *scenario + "$",
+		"-args", "-report=" + reportPath}
+	args = append(args, strings.Fields(*testArgs)...)
+	if _, err := run.Default.Run(ctx, run.Cmd{Name: "go", Args: args, Dir: *testDir, Tee: true}); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(reportPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return bisect.Value(f, *scenario, *metric)
+}
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", "", "path of the kubeconfig, defaults to $KUBECONFIG or ~/.kube/config")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <good build> ... <bad build>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "a build is a tag of the agent and operator images, or agent=operator tags or digests\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *scenario == "" || flag.NArg() < 2 || *repeat < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var builds []bisect.Build
+	for _, arg := range flag.Args() {
+		b, err := bisect.ParseBuild(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		builds = append(builds, b)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		log.Fatal("error loading kubeconfig: ", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal("error creating kubernetes client: ", err)
+	}
+	im := images{client: client}
+	ctx := context.Background()
+
+	agentTemplate, operatorTemplate, err := im.templates(ctx)
+	if err != nil {
+		log.Fatal("error getting the Cilium pod templates: ", err)
+	}
+	err = bisectBuilds(ctx, im, builds)
+	// Restore the images the cluster was deployed with.
+	log.Printf("Restoring the agent and operator images")
+	if err := im.update(ctx,
+		func(template *corev1.PodTemplateSpec) { *template = agentTemplate },
+		func(template *corev1.PodTemplateSpec) { *template = operatorTemplate },
+	); err != nil {
+		log.Printf("error restoring the Cilium images: %s", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// bisectBuilds measures the first of builds, then searches for the first
+// build regressing compared to it. Each build is measured repeat times and
+// the median of its measurements compared, a single run being easily skewed
+// by a noisy node.
+func bisectBuilds(ctx context.Context, im images, builds []bisect.Build) error {
+	step := 0
+	value := func(i int) (float64, error) {
+		if err := im.set(ctx, builds[i]); err != nil {
+			return 0, err
+		}
+		var values []float64
+		for n := 0; n < *repeat; n++ {
+			v, err := measure(ctx, step, builds[i])
+			step++
+			if err != nil {
+				return 0, fmt.Errorf("error measuring %s: %w", builds[i], err)
+			}
+			values = append(values, v)
+		}
+		v := bisect.Median(values)
+		log.Printf("%s of %s with %s: %g (median of %v)", *metric, *scenario, builds[i], v, values)
+		return v, nil
+	}
+
+	base, err := value(0)
+	if err != nil {
+		return err
+	}
+	first, err := bisect.First(len(builds), func(i int) (bool, error) {
+		v, err := value(i)
+		if err != nil {
+			return false, err
+		}
+		return bisect.Regressed(base, v, *threshold), nil
+	})
+	switch {
+	case errors.Is(err, bisect.ErrNoRegression):
+		fmt.Printf("%s doesn't regress %s of %s by more than %.0f%% of %g with %s\n",
+			builds[len(builds)-1], *metric, *scenario, 100**threshold, base, builds[0])
+	case err != nil:
+		return err
+	default:
+		fmt.Printf("%s is the first build regressing %s of %s by more than %.0f%% of %g with %s\n",
+			builds[first], *metric, *scenario, 100**threshold, base, builds[0])
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// cleanup deletes the Kubernetes objects recorded in the ledger of a perf test
+// run that didn't get to clean up after itself, e.g.
+//
+//	cleanup -ledger gke/perf-test.ledger
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/cilium/cilium-perf-test/internal/ledger"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+
+	// auth provider for GCP, enables the client to authenticate with GKE without external
+	// dependencies (e.g. gcloud CLI)
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+)
+
+func main() {
+	path := flag.String("ledger", "perf-test.ledger", "path of the ledger of the run to clean up")
+	kubeconfig := flag.String("kubeconfig", "", "path of the kubeconfig, defaults to $KUBECONFIG or ~/.kube/config")
+	flag.Parse()
+
+	objects, err := ledger.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries := objects.Entries()
+	if len(entries) == 0 {
+		log.Printf("Nothing to clean up in %s", *path)
+		return
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		log.Fatal("error loading kubeconfig: ", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatal("error creating kubernetes client: ", err)
+	}
+
+	log.Printf("Deleting %d objects recorded in %s...", len(entries), *path)
+	deleteEntry := ledger.Deleter(client)
+	if err := objects.Teardown(func(e ledger.Entry) error {
+		log.Printf("Deleting %s", e)
+		return deleteEntry(e)
+	}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+// profdiff compares the top functions of the Cilium agent profiles collected
+// by two perf test runs, e.g.
+//
+//	profdiff -kind cpu artifacts/run-1/big-load artifacts/run-2/big-load
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cilium/cilium-perf-test/internal/profile"
+)
+
+func main() {
+	kind := flag.String("kind", "cpu", "kind of profiles to compare: cpu, heap or goroutine")
+	top := flag.Int("n", 20, "number of functions to show")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <base dir> <dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	base, err := profile.Load(flag.Arg(0), *kind)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current, err := profile.Load(flag.Arg(1), *kind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deltas := profile.Diff(profile.Top(base), profile.Top(current))
+	if len(deltas) > *top {
+		deltas = deltas[:*top]
+	}
+
+	fmt.Printf("%15s %15s %15s  %s\n", "base", "current", "delta", "function")
+	for _, d := range deltas {
+		fmt.Printf("%15d %15d %+15d  %s\n", d.Base, d.Current, d.Current-d.Base, d.Function)
+	}
+}
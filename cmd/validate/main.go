@@ -0,0 +1,94 @@
+// validate checks the manifests of every Cilium version of the registry and
+// the shared manifests without a cluster, the way the gke and minikube tests
+// deploy them. It exits with an error when a manifest can't be deployed, e.g.
+// from the root of the repository:
+//
+//	go run ./cmd/validate
+//
+// The Kubernetes schemas it validates the objects against are trimmed from the
+// OpenAPI document of a cluster with -update-schema:
+//
+//	kubectl get --raw /openapi/v2 > openapi.json
+//	go run ./cmd/validate -update-schema openapi.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/cilium/cilium-perf-test/internal/validate"
+)
+
+var (
+	manifestPath       = flag.String("manifest-path", "manifests", "path that the per Cilium version manifests are in")
+	sharedManifestPath = flag.String("shared-manifest-path", "shared", "path that Cilium version agnostic manifests are in")
+	schemaPath         = flag.String("schema", "internal/validate/schemas/kubernetes.json", "Kubernetes OpenAPI schemas the objects are validated against")
+	updateSchema       = flag.String("update-schema", "", "Kubernetes OpenAPI v2 document to trim into -schema, e.g. the output of kubectl get --raw /openapi/v2, instead of validating")
+	strict             = flag.Bool("strict", false, "exit with an error on warnings too")
+	ciliumNS           = flag.String("ns", "cilium-perf", "namespace that the gke tests deploy Cilium in")
+	monitoringNS       = flag.String("prom-ns", "cilium-monitoring", "namespace that the gke tests deploy the monitoring stack in")
+)
+
+// trimSchema writes the schemas of the kinds the tests deploy of the OpenAPI
+// document path to -schema.
+func trimSchema(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	trimmed, err := validate.Trim(data, validate.SupportedKinds)
+	if err != nil {
+		return fmt.Errorf("failed to trim %s: %w", path, err)
+	}
+	return ioutil.WriteFile(*schemaPath, append(trimmed, '\n'), 0644)
+}
+
+func main() {
+	flag.Parse()
+	if *updateSchema != "" {
+		if err := trimSchema(*updateSchema); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	s, err := validate.LoadSchema(*schemaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	validator := validate.New(s)
+
+	groups, err := validate.Layout{
+		ManifestPath:        *manifestPath,
+		SharedManifestPath:  *sharedManifestPath,
+		CiliumNamespace:     *ciliumNS,
+		MonitoringNamespace: *monitoringNS,
+	}.Groups()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var errs, warnings int
+	for _, g := range groups {
+		findings, err := validator.Validate(g.Targets...)
+		if err != nil {
+			log.Fatalf("error validating %s: %s", g.Name, err)
+		}
+		for _, f := range findings {
+			fmt.Println(f)
+			if f.Severity == validate.Error {
+				errs++
+			} else {
+				warnings++
+			}
+		}
+	}
+
+	fmt.Printf("%d manifest groups: %d errors, %d warnings\n", len(groups), errs, warnings)
+	if errs > 0 || *strict && warnings > 0 {
+		os.Exit(1)
+	}
+}
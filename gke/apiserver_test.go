@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/apiload"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	flowcontrolv1alpha1 "k8s.io/api/flowcontrol/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// apiserverMetrics is a snapshot of the metrics exposed by kube-apiserver.
+type apiserverMetrics map[string]*dto.MetricFamily
+
+// scrapeAPIServerMetrics fetches the kube-apiserver metrics. This requires the
+// test user to be allowed to get the /metrics non-resource URL.
+func scrapeAPIServerMetrics() (apiserverMetrics, error) {
+	data, err := harness.KubeClient().CoreV1().RESTClient().Get().
+		AbsPath("/metrics").
+		DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get apiserver metrics: %w", err)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse apiserver metrics: %w", err)
+	}
+	return families, nil
+}
+
+// sum returns the sum of the samples of the counter or gauge name whose labels
+// match all of labels.
+func (m apiserverMetrics) sum(name string, labels map[string]string) float64 {
+	family, ok := m[name]
+	if !ok {
+		return 0
+	}
+
+	var total float64
+	for _, metric := range family.Metric {
+		if !labelsMatch(metric, labels) {
+			continue
+		}
+		switch {
+		case metric.Counter != nil:
+			total += metric.Counter.GetValue()
+		case metric.Gauge != nil:
+			total += metric.Gauge.GetValue()
+		case metric.Untyped != nil:
+			total += metric.Untyped.GetValue()
+		}
+	}
+	return total
+}
+
+func labelsMatch(metric *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range metric.Label {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+// ciliumFlowSchema is the name of the FlowSchema matching the requests of the
+// Cilium service accounts.
+const ciliumFlowSchema = "cilium-perf-test"
+
+// ciliumClient identifies the requests of the Cilium agents and operator. Its
+// FlowSchema is only set once createCiliumFlowSchema created it.
+var ciliumClient = apiload.Client{UserAgent: "cilium", Group: "cilium.io"}
+
+// createCiliumFlowSchema creates a FlowSchema matching the requests of the
+// Cilium agents and operator, so that all of them can be counted on apiservers
+// that don't label their requests with the user agent. They are dispatched to
+// the workload-low priority level, like the requests of the service accounts
+// outside of kube-system by default. Apiservers without API priority and
+// fairness enabled don't serve FlowSchemas, only the requests to the Cilium
+// custom resources are counted then, and the report marks them as partial.
+func createCiliumFlowSchema(t *testing.T, namespace string) {
+	var subjects []flowcontrolv1alpha1.Subject
+	for _, name := range []string{"cilium", "cilium-operator"} {
+		subjects = append(subjects, flowcontrolv1alpha1.Subject{
+			Kind:           flowcontrolv1alpha1.SubjectKindServiceAccount,
+			ServiceAccount: &flowcontrolv1alpha1.ServiceAccountSubject{Namespace: namespace, Name: name},
+		})
+	}
+	fs := &flowcontrolv1alpha1.FlowSchema{
+		ObjectMeta: metav1.ObjectMeta{Name: ciliumFlowSchema},
+		Spec: flowcontrolv1alpha1.FlowSchemaSpec{
+			PriorityLevelConfiguration: flowcontrolv1alpha1.PriorityLevelConfigurationReference{Name: "workload-low"},
+			// Ahead of the service-accounts FlowSchema.
+			MatchingPrecedence: 8000,
+			DistinguisherMethod: &flowcontrolv1alpha1.FlowDistinguisherMethod{
+				Type: flowcontrolv1alpha1.FlowDistinguisherMethodByUserType,
+			},
+			Rules: []flowcontrolv1alpha1.PolicyRulesWithSubjects{{
+				Subjects: subjects,
+				ResourceRules: []flowcontrolv1alpha1.ResourcePolicyRule{{
+					Verbs:        []string{flowcontrolv1alpha1.VerbAll},
+					APIGroups:    []string{flowcontrolv1alpha1.APIGroupAll},
+					Resources:    []string{flowcontrolv1alpha1.ResourceAll},
+					ClusterScope: true,
+					Namespaces:   []string{flowcontrolv1alpha1.NamespaceEvery},
+				}},
+				NonResourceRules: []flowcontrolv1alpha1.NonResourcePolicyRule{{
+					Verbs:           []string{flowcontrolv1alpha1.VerbAll},
+					NonResourceURLs: []string{flowcontrolv1alpha1.NonResourceAll},
+				}},
+			}},
+		},
+	}
+
+	_, err := harness.KubeClient().FlowcontrolV1alpha1().FlowSchemas().Create(context.TODO(), fs, metav1.CreateOptions{})
+	switch {
+	case apierrors.IsNotFound(err), apierrors.IsForbidden(err):
+		t.Log("not counting all the Cilium apiserver requests, FlowSchema not created:", err)
+		return
+	case err != nil && !apierrors.IsAlreadyExists(err):
+		t.Fatal("error creating the Cilium FlowSchema", err)
+	}
+	recordObject(t, flowcontrolv1alpha1.SchemeGroupVersion.WithKind("FlowSchema"), "", ciliumFlowSchema)
+	ciliumClient.FlowSchema = ciliumFlowSchema
+}
+
+// apiserverLoad measures the load Cilium puts on kube-apiserver during a test
+// case.
+type apiserverLoad struct {
+	start  time.Time
+	before apiserverMetrics
+}
+
+// startAPIServerLoad scrapes the apiserver metrics at the start of a test case.
+// It returns nil if they can't be scraped.
+func startAPIServerLoad(t *testing.T) *apiserverLoad {
+	before, err := scrapeAPIServerMetrics()
+	if err != nil {
+		t.Log("not measuring apiserver load:", err)
+		return nil
+	}
+	return &apiserverLoad{start: time.Now(), before: before}
+}
+
+// report adds the load Cilium put on the apiserver since startAPIServerLoad to
+// report.
+func (l *apiserverLoad) report(t *testing.T, report *caseReport) {
+	if l == nil {
+		return
+	}
+
+	after, err := scrapeAPIServerMetrics()
+	if err != nil {
+		t.Fatal("error measuring apiserver load", err)
+	}
+	load := apiload.Diff(l.before, after, time.Since(l.start), ciliumClient)
+	report.add("apiserver", load)
+	fmt.Printf("apiserver load from Cilium: %s\n", load)
+}
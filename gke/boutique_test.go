@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/locust"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	kt "github.com/dlespiau/kube-test-harness"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// locustPercentilesHeader introduces the latency percentiles Locust
+	// prints when it stops.
+	locustPercentilesHeader = "Percentage of the requests completed within given times"
+	// loadGenerator is the Deployment of the microservices demo load
+	// generator.
+	loadGenerator = "loadgenerator"
+)
+
+var boutiqueUserCounts string
+
+func init() {
+	flag.StringVar(&boutiqueUserCounts, "boutique-users", "100,500", "comma separated list of concurrent user counts, one microservices demo test is run for each")
+}
+
+// boutiqueUsers returns the concurrent user counts given with -boutique-users.
+func boutiqueUsers(t *testing.T) []int {
+	var users []int
+	for _, s := range strings.Split(boutiqueUserCounts, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			t.Fatalf("invalid -boutique-users value %q: %s", s, err)
+		}
+		users = append(users, n)
+	}
+	return users
+}
+
+// withLoadGeneratorUsers sets the number of concurrent users simulated by the
+// microservices demo load generator.
+func withLoadGeneratorUsers(users int) objectTransform {
+	return func(obj runtime.Object) {
+		d, ok := obj.(*appsv1.Deployment)
+		if !ok || d.Name != loadGenerator {
+			return
+		}
+		for i := range d.Spec.Template.Spec.Containers {
+			env := d.Spec.Template.Spec.Containers[i].Env
+			for j := range env {
+				if env[j].Name == "USERS" {
+					env[j].Value = strconv.Itoa(users)
+				}
+			}
+		}
+	}
+}
+
+// withLoadGeneratorRunTime makes the microservices demo load generator stop
+// after d, so that Locust prints its latency percentiles, and keeps the
+// container running so that its logs can be read. Like the loadgen.sh
+// entrypoint of the image, it first checks the frontend answers. The load
+// generator is deployed with no replicas, runBoutique starts it once the
+// services are ready so that startup doesn't cut into the run time.
+func withLoadGeneratorRunTime(d time.Duration) objectTransform {
+	return func(obj runtime.Object) {
+		dep, ok := obj.(*appsv1.Deployment)
+		if !ok || dep.Name != loadGenerator {
+			return
+		}
+		replicas := int32(0)
+		dep.Spec.Replicas = &replicas
+		script := fmt.Sprintf(`status=$(curl --silent --output /dev/null --write-out "%%{http_code}" "http://${FRONTEND_ADDR}")
+if [ "$status" -ne 200 ]; then echo "frontend returned $status"; exit 1; fi
+locust --host="http://${FRONTEND_ADDR}" --no-web -c "${USERS:-10}" -t %ds 2>&1
+sleep infinity`, int(d.Seconds()))
+		for i := range dep.Spec.Template.Spec.Containers {
+			dep.Spec.Template.Spec.Containers[i].Command = []string{"/bin/sh", "-c", script}
+		}
+	}
+}
+
+// runBoutique waits for all the microservices demo services to be ready,
+// starts the load generator, lets it run for the test duration and reports its
+// statistics.
+func runBoutique(t *testing.T, test *kt.Test, report *caseReport) {
+	if err := waitForServiceEndpoints(test.Namespace, 5*time.Minute); err != nil {
+		t.Fatal("error waiting for services", err)
+	}
+
+	scaleDeployment(t, test.Namespace, loadGenerator, 1)
+	waitForWorkloads(t, []readiness.Object{
+		{Kind: readiness.Deployment, Namespace: test.Namespace, Name: loadGenerator},
+	}, 5*time.Minute)
+	pods := test.ListPods(test.Namespace, metav1.ListOptions{LabelSelector: "app=" + loadGenerator})
+	if len(pods.Items) == 0 {
+		t.Fatal("load generator pod not found")
+	}
+	pod := &pods.Items[0]
+
+	log.Printf("Letting the load generator run for %v to gather metrics...", duration)
+	<-time.After(duration)
+
+	var logs bytes.Buffer
+	if err := wait.Poll(10*time.Second, 5*time.Minute, func() (bool, error) {
+		logs.Reset()
+		if err := test.PodLogs(&logs, pod, "main"); err != nil {
+			return false, err
+		}
+		return strings.Contains(logs.String(), locustPercentilesHeader), nil
+	}); err != nil {
+		t.Log("load generator did not print latency percentiles:", err)
+	}
+
+	stats, err := locust.Parse(&logs)
+	if err != nil {
+		t.Fatal("error parsing load generator statistics", err)
+	}
+	report.add("locust", stats)
+	if stats.ErrorRateRising(0.01) {
+		report.invalidate(t, "load generator error rate rose during the run")
+	}
+
+	fmt.Printf("Load generator statistics:\n")
+	for _, s := range append(stats.Final.Endpoints, stats.Final.Aggregated) {
+		fmt.Printf("%s: %.2f req/s, %d/%d failed, p50=%v p95=%v p99=%v\n",
+			s.Name, s.RPS, s.Failures, s.Requests,
+			s.Percentiles["50%"], s.Percentiles["95%"], s.Percentiles["99%"])
+	}
+}
+
+// waitForServiceEndpoints waits for every service of namespace to have at least
+// one ready endpoint.
+func waitForServiceEndpoints(namespace string, timeout time.Duration) error {
+	client := harness.KubeClient().CoreV1()
+	return wait.Poll(time.Second, timeout, func() (bool, error) {
+		services, err := client.Services(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, svc := range services.Items {
+			ep, err := client.Endpoints(namespace).Get(context.TODO(), svc.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			ready := 0
+			for _, subset := range ep.Subsets {
+				ready += len(subset.Addresses)
+			}
+			if ready == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
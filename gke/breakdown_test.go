@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/imbalance"
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var imbalanceRatio float64
+
+func init() {
+	flag.Float64Var(&imbalanceRatio, "imbalance-ratio", 3, "ratio to the median of the other nodes above which a metric of a node is flagged as imbalanced")
+}
+
+// nodeBreakdown holds the metrics of the Cilium pods of a node and the
+// workload placed on it.
+type nodeBreakdown struct {
+	// Pods are the Cilium pods of the node.
+	Pods []string `json:"pods"`
+	// WorkloadPods is the number of pods of the test case on the node.
+	WorkloadPods int `json:"workload_pods"`
+	// Metrics holds the average of each metric over the test case, summed
+	// over the Cilium pods of the node.
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// breakdownReport is the per node breakdown of a test case.
+type breakdownReport struct {
+	Nodes      map[string]*nodeBreakdown `json:"nodes"`
+	Imbalances []imbalance.Finding       `json:"imbalances,omitempty"`
+}
+
+// seriesName returns the name of the series of the metric name broken down by
+// label, e.g. "endpoints{endpoint_state=ready}".
+func seriesName(name metrics.Name, label, value string) string {
+	if label == "" {
+		return string(name)
+	}
+	return fmt.Sprintf("%s{%s=%s}", name, label, value)
+}
+
+// queryNodeBreakdown adds the average of the default and extra metrics over
+// the last duration for each node to report, along with the number of pods of
+// the test case namespace on each node, and flags the metrics much higher on a
+// node than on the others.
+func queryNodeBreakdown(t *testing.T, base string, duration time.Duration, extraMetrics []metrics.Name, namespace string, report *caseReport) {
+	breakdown := breakdownReport{Nodes: make(map[string]*nodeBreakdown)}
+	node := func(name string) *nodeBreakdown {
+		n, ok := breakdown.Nodes[name]
+		if !ok {
+			n = &nodeBreakdown{Metrics: make(map[string]float64)}
+			breakdown.Nodes[name] = n
+		}
+		return n
+	}
+
+	client := harness.KubeClient().CoreV1()
+	ciliumPods, err := client.Pods(ciliumNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal("error listing Cilium pods", err)
+	}
+	podNodes := make(map[string]string)
+	for _, pod := range ciliumPods.Items {
+		podNodes[pod.Name] = pod.Spec.NodeName
+		n := node(pod.Spec.NodeName)
+		n.Pods = append(n.Pods, pod.Name)
+	}
+	workload, err := client.Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal("error listing workload pods", err)
+	}
+	for _, pod := range workload.Items {
+		if pod.Spec.NodeName != "" {
+			node(pod.Spec.NodeName).WorkloadPods++
+		}
+	}
+
+	promv1api := newPrometheusAPI(t, base)
+
+	names := append(append([]metrics.Name(nil), defaultMetrics...), extraMetrics...)
+	m := monitoring()
+	set := ciliumMetrics()
+	// values holds the value of each series by node, for imbalance
+	// detection.
+	values := make(map[string]map[string]float64)
+	for _, name := range names {
+		q, err := set.ByPod(name, m, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q = fmt.Sprintf("avg_over_time((%s)[%ds:1m])", q, int(duration.Seconds()))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		result, _, err := promv1api.Query(ctx, q, time.Now())
+		cancel()
+		if err != nil {
+			t.Fatal("error querying Prometheus", err)
+		}
+		vector, ok := result.(model.Vector)
+		if !ok {
+			t.Fatalf("unexpected result type %s for query %q", result.Type(), q)
+		}
+
+		by := set[name].By
+		for _, sample := range vector {
+			nodeName, ok := podNodes[string(sample.Metric[model.LabelName(m.TargetPodLabel)])]
+			if !ok {
+				// The pod is gone.
+				continue
+			}
+			series := seriesName(name, by, string(sample.Metric[model.LabelName(by)]))
+			node(nodeName).Metrics[series] += float64(sample.Value)
+			if values[series] == nil {
+				values[series] = make(map[string]float64)
+			}
+			values[series][nodeName] += float64(sample.Value)
+		}
+	}
+
+	breakdown.Imbalances = imbalance.Detect(values, imbalanceRatio)
+	report.add("nodes", breakdown)
+
+	var nodes []string
+	for name := range breakdown.Nodes {
+		nodes = append(nodes, name)
+	}
+	sort.Strings(nodes)
+	fmt.Printf("Per node results:\n")
+	for _, name := range nodes {
+		n := breakdown.Nodes[name]
+		fmt.Printf("%s: %d workload pods, Cilium pods %v\n%v\n", name, n.WorkloadPods, n.Pods, n.Metrics)
+	}
+	for _, f := range breakdown.Imbalances {
+		fmt.Printf("Imbalance: %s is %.1fx higher on %s than the median of the other nodes (%g vs %g)\n",
+			f.Metric, f.Ratio, f.Node, f.Value, f.Median)
+	}
+}
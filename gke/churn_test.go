@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	kt "github.com/dlespiau/kube-test-harness"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const churnDeployment = "churn"
+
+var (
+	churnMin      int
+	churnMax      int
+	churnInterval time.Duration
+)
+
+func init() {
+	flag.IntVar(&churnMin, "churn-min", 1, "number of replicas the churn deployment is scaled down to")
+	flag.IntVar(&churnMax, "churn-max", 30, "number of replicas the churn deployment is scaled up to")
+	flag.DurationVar(&churnInterval, "churn-interval", 30*time.Second, "time between two scale operations of the churn deployment")
+}
+
+// runChurn scales the churn deployment back and forth between churnMin and
+// churnMax every churnInterval for the whole test duration, then checks that
+// the number of Cilium endpoints goes back to where it was before the churn.
+// The baseline is taken with the deployment at churnMin, where the churn ends.
+func runChurn(t *testing.T, test *kt.Test, report *caseReport) {
+	scaleChurn(t, test, churnMin)
+	baseline := countCiliumObjects(t, "ciliumendpoints")
+	log.Printf("Churning deployment %s between %d and %d replicas every %v, baseline is %d endpoints",
+		churnDeployment, churnMin, churnMax, churnInterval, baseline)
+
+	churn(t, test, churnInterval, duration)
+
+	var count int
+	if err := wait.Poll(5*time.Second, 2*time.Minute, func() (bool, error) {
+		count = countCiliumObjects(t, "ciliumendpoints")
+		return count == baseline, nil
+	}); err != nil {
+		t.Errorf("endpoint count did not return to baseline after churn: got %d, want %d", count, baseline)
+	}
+}
+
+// churn scales the churn deployment of the test namespace back and forth
+// between churnMin and churnMax every interval for d, then scales it down to
+// churnMin and waits for it to be ready.
+func churn(t *testing.T, test *kt.Test, interval, d time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(d)
+
+	up := true
+	for done := false; !done; {
+		replicas := churnMin
+		if up {
+			replicas = churnMax
+		}
+		scaleDeployment(t, test.Namespace, churnDeployment, replicas)
+		up = !up
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			done = true
+		}
+	}
+
+	scaleChurn(t, test, churnMin)
+}
+
+// scaleChurn scales the churn deployment of the test namespace to replicas and
+// waits for it to be ready.
+func scaleChurn(t *testing.T, test *kt.Test, replicas int) {
+	scaleDeployment(t, test.Namespace, churnDeployment, replicas)
+	waitForWorkloads(t, []readiness.Object{
+		{Kind: readiness.Deployment, Namespace: test.Namespace, Name: churnDeployment},
+	}, 5*time.Minute)
+}
+
+func scaleDeployment(t *testing.T, namespace, name string, replicas int) {
+	deployments := harness.KubeClient().AppsV1().Deployments(namespace)
+	scale, err := deployments.GetScale(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get scale of deployment %s: %s", name, err)
+	}
+	scale.Spec.Replicas = int32(replicas)
+	if _, err := deployments.UpdateScale(context.TODO(), name, scale, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to scale deployment %s to %d: %s", name, replicas, err)
+	}
+}
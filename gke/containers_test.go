@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// containerComponent is a set of containers whose cAdvisor metrics are
+// reported.
+type containerComponent struct {
+	name       string
+	containers metrics.Containers
+}
+
+// containerComponents returns the components the container metrics are
+// reported for, the workload being the containers of namespace. Hubble runs
+// in the agent container, only Hubble Relay has its own pods, when deployed.
+func containerComponents(namespace string) []containerComponent {
+	return []containerComponent{
+		{name: "cilium-agent", containers: metrics.Containers{Pod: "cilium-[a-z0-9]{5}", Container: "cilium-agent"}},
+		{name: "cilium-operator", containers: metrics.Containers{Pod: "cilium-operator-.*", Container: "cilium-operator"}},
+		{name: "hubble-relay", containers: metrics.Containers{Pod: "hubble-relay-.*", Container: "hubble-relay"}},
+		{name: "workload", containers: metrics.Containers{Namespace: namespace}},
+	}
+}
+
+// queryContainerMetrics adds the cAdvisor metrics of the Cilium components and
+// of the workload of namespace over the last duration to report, broken down
+// by node.
+func queryContainerMetrics(t *testing.T, base string, duration time.Duration, namespace string, report *caseReport) {
+	promv1api := newPrometheusAPI(t, base)
+	r := prometheusv1.Range{
+		Start: time.Now().Add(-duration),
+		End:   time.Now(),
+		Step:  time.Minute,
+	}
+
+	// results holds the series of each node, by component and metric.
+	results := make(map[string]map[metrics.Name]model.Value)
+	defer report.add("containers", results)
+
+	fmt.Printf("Container results:\n")
+	for _, component := range containerComponents(namespace) {
+		results[component.name] = make(map[metrics.Name]model.Value)
+		for _, name := range metrics.ContainerMetrics {
+			q, err := monitoring().ContainerQuery(name, component.containers, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			result, _, err := promv1api.QueryRange(ctx, q, r)
+			cancel()
+			if err != nil {
+				t.Fatal("error querying Prometheus", err)
+			}
+			fmt.Printf("%s %s (%s):\n%v\n", component.name, name, q, result)
+			results[component.name][name] = result
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// ciliumResources maps the kinds of the namespaced Cilium custom resources that
+// can be deployed from manifests to their resource name.
+// validate.SupportedKinds lists them too.
+var ciliumResources = map[string]string{
+	"CiliumNetworkPolicy": "ciliumnetworkpolicies",
+}
+
+// ciliumEndpoint holds the fields of the CiliumEndpoint custom resource the
+// tests care about.
+type ciliumEndpoint struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Identity struct {
+			ID     int64    `json:"id"`
+			Labels []string `json:"labels"`
+		} `json:"identity"`
+	} `json:"status"`
+}
+
+// listCiliumObjects lists the Cilium custom resources of type resource, in
+// namespace or across all namespaces if namespace is "", and decodes them into
+// list, which must have an Items field.
+func listCiliumObjects(t *testing.T, resource, namespace string, list interface{}) {
+	absPath := "/apis/cilium.io/v2/"
+	if namespace != "" {
+		absPath += "namespaces/" + namespace + "/"
+	}
+	data, err := harness.KubeClient().CoreV1().RESTClient().Get().
+		AbsPath(absPath + resource).
+		DoRaw(context.TODO())
+	if err != nil {
+		t.Fatalf("error listing %s: %s", resource, err)
+	}
+
+	if err := json.Unmarshal(data, list); err != nil {
+		t.Fatalf("error decoding %s: %s", resource, err)
+	}
+}
+
+// countCiliumObjects returns the number of Cilium custom resources of type
+// resource, e.g. "ciliumendpoints", across all namespaces.
+func countCiliumObjects(t *testing.T, resource string) int {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	listCiliumObjects(t, resource, "", &list)
+	return len(list.Items)
+}
+
+// listCiliumEndpoints returns the CiliumEndpoints of namespace.
+func listCiliumEndpoints(t *testing.T, namespace string) []ciliumEndpoint {
+	var list struct {
+		Items []ciliumEndpoint `json:"items"`
+	}
+	listCiliumObjects(t, "ciliumendpoints", namespace, &list)
+	return list.Items
+}
+
+// createCiliumObject creates the Cilium custom resource defined by the YAML
+// document doc in namespace.
+func createCiliumObject(t *testing.T, doc []byte, namespace string) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+
+	var obj struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	resource, ok := ciliumResources[obj.Kind]
+	if obj.APIVersion != "cilium.io/v2" || !ok {
+		t.Fatalf("k8s resource %s/%s not handled", obj.APIVersion, obj.Kind)
+	}
+
+	if _, err := harness.KubeClient().CoreV1().RESTClient().Post().
+		AbsPath("/apis/cilium.io/v2/namespaces/" + namespace + "/" + resource).
+		Body(data).
+		DoRaw(context.TODO()); err != nil {
+		t.Fatalf("failed to create %s: %s", obj.Kind, err)
+	}
+	recordObject(t, schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: obj.Kind}, namespace, obj.Metadata.Name)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/yaml"
+)
+
+// agentCommands are run in every Cilium agent and their output added to the
+// diagnostic bundle.
+var agentCommands = [][]string{
+	{"cilium", "status", "--all-controllers"},
+	{"cilium", "endpoint", "list"},
+}
+
+// diagnosticBundle is a gzipped tarball the diagnostics are written to.
+type diagnosticBundle struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	// errors are the errors hit while collecting the diagnostics, they are
+	// added to the bundle instead of failing the collection.
+	errors []string
+}
+
+func newDiagnosticBundle() *diagnosticBundle {
+	b := &diagnosticBundle{}
+	b.gz = gzip.NewWriter(&b.buf)
+	b.tw = tar.NewWriter(b.gz)
+	return b
+}
+
+func (b *diagnosticBundle) add(name string, data []byte) {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		b.errorf("failed to add %s: %s", name, err)
+		return
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		b.errorf("failed to add %s: %s", name, err)
+	}
+}
+
+// addYAML adds obj encoded as YAML to the bundle.
+func (b *diagnosticBundle) addYAML(name string, obj interface{}) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		b.errorf("failed to encode %s: %s", name, err)
+		return
+	}
+	b.add(name, data)
+}
+
+func (b *diagnosticBundle) errorf(format string, args ...interface{}) {
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+// write closes the bundle and writes it to file.
+func (b *diagnosticBundle) write(file string) error {
+	if len(b.errors) > 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	if err := b.gz.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b.buf.Bytes(), 0644)
+}
+
+// collectDiagnostics writes a diagnostic bundle for the test case scenario to
+// the artifacts directory if the test failed. It must be deferred after
+// test.Close so that it runs before the test namespace is deleted.
+//
+// The bundle holds the logs, including the ones of the previous containers, of
+// the Cilium pods and of the pods of the test namespaces, the events and
+// ConfigMaps of these namespaces, the node conditions and the output of
+// agentCommands in every agent.
+func collectDiagnostics(t *testing.T, namespace, scenario string) {
+	if !t.Failed() {
+		return
+	}
+
+	file := filepath.Join(artifactsPath, scenario, fmt.Sprintf("diagnostics-%s.tar.gz", time.Now().Format("20060102-150405")))
+	log.Printf("Test failed, collecting diagnostics to %s...", file)
+
+	b := newDiagnosticBundle()
+	client := harness.KubeClient().CoreV1()
+
+	nodes, err := client.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list nodes: %s", err)
+	} else {
+		var conditions strings.Builder
+		for _, node := range nodes.Items {
+			fmt.Fprintf(&conditions, "%s:\n", node.Name)
+			for _, c := range node.Status.Conditions {
+				fmt.Fprintf(&conditions, "  %s=%s since %v: %s %s\n", c.Type, c.Status, c.LastTransitionTime, c.Reason, c.Message)
+			}
+		}
+		b.add("nodes.txt", []byte(conditions.String()))
+	}
+
+	for _, ns := range diagnosticNamespaces(b, namespace) {
+		collectNamespaceDiagnostics(b, ns)
+	}
+
+	config := restConfig(t)
+	agents, err := client.Pods(ciliumNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: agentSelector})
+	if err != nil {
+		b.errorf("failed to list Cilium agents: %s", err)
+	} else {
+		for i := range agents.Items {
+			pod := &agents.Items[i]
+			for _, cmd := range agentCommands {
+				stdout, stderr, err := execInPod(config, pod, "cilium-agent", cmd...)
+				if err != nil {
+					b.errorf("failed to run %q in %s: %s", strings.Join(cmd, " "), pod.Name, err)
+				}
+				b.add(filepath.Join(ciliumNamespace, pod.Name, strings.Join(cmd, "-")+".txt"), append(stdout, stderr...))
+			}
+		}
+	}
+
+	if err := b.write(file); err != nil {
+		t.Log("error writing diagnostic bundle", err)
+	}
+}
+
+// diagnosticNamespaces returns the Cilium namespace, namespace and the
+// namespaces test cases create next to it, named after it.
+func diagnosticNamespaces(b *diagnosticBundle, namespace string) []string {
+	namespaces := []string{ciliumNamespace}
+	if namespace == "" {
+		return namespaces
+	}
+
+	list, err := harness.KubeClient().CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list namespaces: %s", err)
+		return append(namespaces, namespace)
+	}
+	for _, ns := range list.Items {
+		if ns.Name == namespace || strings.HasPrefix(ns.Name, namespace+"-") {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	return namespaces
+}
+
+// collectNamespaceDiagnostics adds the pod logs, events and ConfigMaps of
+// namespace to the bundle.
+func collectNamespaceDiagnostics(b *diagnosticBundle, namespace string) {
+	client := harness.KubeClient().CoreV1()
+
+	pods, err := client.Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list pods of %s: %s", namespace, err)
+	} else {
+		b.addYAML(filepath.Join(namespace, "pods.yaml"), pods)
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			for _, container := range pod.Spec.Containers {
+				addContainerLogs(b, pod, container.Name, false)
+				if containerRestarted(pod, container.Name) {
+					addContainerLogs(b, pod, container.Name, true)
+				}
+			}
+		}
+	}
+
+	events, err := client.Events(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list events of %s: %s", namespace, err)
+	} else {
+		sort.Slice(events.Items, func(i, j int) bool {
+			return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+		})
+		var out strings.Builder
+		for _, e := range events.Items {
+			fmt.Fprintf(&out, "%v %s %s/%s %s: %s (x%d)\n",
+				e.LastTimestamp, e.Type, e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Reason, e.Message, e.Count)
+		}
+		b.add(filepath.Join(namespace, "events.txt"), []byte(out.String()))
+	}
+
+	configMaps, err := client.ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list ConfigMaps of %s: %s", namespace, err)
+	} else {
+		b.addYAML(filepath.Join(namespace, "configmaps.yaml"), configMaps)
+	}
+}
+
+// addContainerLogs adds the logs of container to the bundle, the ones of the
+// previous instance of the container if previous is set.
+func addContainerLogs(b *diagnosticBundle, pod *corev1.Pod, container string, previous bool) {
+	name := container + ".log"
+	if previous {
+		name = container + ".previous.log"
+	}
+	logs, err := harness.KubeClient().CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+	}).DoRaw(context.TODO())
+	if err != nil {
+		b.errorf("failed to get logs of %s/%s: %s", pod.Name, name, err)
+		return
+	}
+	b.add(filepath.Join(pod.Namespace, pod.Name, name), logs)
+}
+
+func containerRestarted(pod *corev1.Pod, container string) bool {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == container {
+			return s.RestartCount > 0
+		}
+	}
+	return false
+}
+
+// execInPod runs cmd in container of pod and returns its standard output and
+// error.
+func execInPod(config *rest.Config, pod *corev1.Pod, container string, cmd ...string) (stdout, stderr []byte, err error) {
+	req := harness.KubeClient().CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return nil, nil, err
+	}
+	var outBuf, errBuf bytes.Buffer
+	err = exec.Stream(remotecommand.StreamOptions{
+		Stdout: &outBuf,
+		Stderr: &errBuf,
+	})
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	kt "github.com/dlespiau/kube-test-harness"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	ciliumDaemonSet = "cilium"
+	agentSelector   = "k8s-app=cilium"
+)
+
+var (
+	disruptionMode   string
+	disruptionWarmup time.Duration
+)
+
+func init() {
+	flag.StringVar(&disruptionMode, "agent-disruption", "", `how the agents are restarted by the agent-disruption test case, "delete" to delete all agent pods at once, "rollout" for a rolling restart of the DaemonSet, the test case is skipped when empty`)
+	flag.DurationVar(&disruptionWarmup, "disruption-warmup", 30*time.Second, "time the traffic flows before the agents are restarted")
+}
+
+// agentRecovery is the recovery of the agent of a node after a restart.
+type agentRecovery struct {
+	Pod string `json:"pod"`
+	// Ready is the time from the start of the disruption until the new agent
+	// pod was ready.
+	Ready time.Duration `json:"ready"`
+	// Startup is the time from the creation of the new agent pod until it
+	// was ready, during which the node had no agent.
+	Startup time.Duration `json:"startup"`
+	// Bootstrap is the bootstrap duration reported by the agent, if scraped.
+	Bootstrap float64 `json:"bootstrap_seconds,omitempty"`
+}
+
+// disruptionReport holds the results of an agent disruption.
+type disruptionReport struct {
+	Mode string `json:"mode"`
+	// AgentsReady is the time from the start of the disruption until the
+	// agents of all nodes were replaced and ready.
+	AgentsReady time.Duration `json:"agents_ready"`
+	// EndpointsRegenerated is the time from the start of the disruption
+	// until all the endpoints of the new agents were ready.
+	EndpointsRegenerated time.Duration `json:"endpoints_regenerated"`
+	// Nodes holds the recovery of each node.
+	Nodes map[string]agentRecovery `json:"nodes"`
+	loadImpact
+}
+
+// loadImpact summarizes the traffic of the load generator across a
+// disruption.
+type loadImpact struct {
+	// Requests and FailedRequests count the requests sent by the load
+	// generator, and the ones that didn't get a 200 response.
+	Requests       int64 `json:"requests"`
+	FailedRequests int64 `json:"failed_requests"`
+	// Reconnections is the number of connections the load generator had to
+	// reopen after an error.
+	Reconnections int           `json:"reconnections"`
+	P99           time.Duration `json:"p99"`
+	Max           time.Duration `json:"max"`
+}
+
+func newLoadImpact(load *loadResult) loadImpact {
+	impact := loadImpact{
+		Requests:       load.DurationHistogram.Count,
+		FailedRequests: load.errors(),
+		P99:            load.percentile(99),
+		Max:            time.Duration(load.DurationHistogram.Max * float64(time.Second)),
+	}
+	if load.SocketCount > loadConnections {
+		impact.Reconnections = load.SocketCount - loadConnections
+	}
+	return impact
+}
+
+// runAgentDisruption sends traffic through the abchain workload for the test
+// duration and restarts the agents after disruptionWarmup, according to
+// disruptionMode. It reports how long the agents took to recover and how many
+// requests failed meanwhile.
+func runAgentDisruption(t *testing.T, test *kt.Test, report *caseReport) {
+	if disruptionMode != "delete" && disruptionMode != "rollout" {
+		t.Fatalf("unknown agent disruption %q", disruptionMode)
+	}
+
+	job := startLoad(t, test.Namespace, "http://port-abc:3770/", loadQPS, loadConnections, duration)
+	log.Printf("Sending traffic for %v before restarting the agents...", disruptionWarmup)
+	<-time.After(disruptionWarmup)
+
+	old := agentPods(t)
+	start := time.Now()
+	log.Printf("Restarting the agents of %d nodes (%s)...", len(old), disruptionMode)
+	if disruptionMode == "delete" {
+		deleteAgents(t)
+	} else {
+		restartAgents(t)
+	}
+
+	result := disruptionReport{Mode: disruptionMode}
+	var pods []corev1.Pod
+	result.Nodes, pods = waitForNewAgents(t, old, start, 10*time.Minute)
+	result.AgentsReady = time.Since(start)
+	waitForEndpointsReady(t, pods, 5*time.Minute)
+	result.EndpointsRegenerated = time.Since(start)
+
+	load := waitLoad(t, test, test.Namespace, job, duration)
+	result.loadImpact = newLoadImpact(load)
+
+	queryBootstrap(t, prometheusURL(t, test), result.Nodes)
+	report.add("disruption", result)
+
+	fmt.Printf("Agent disruption (%s): agents ready after %v, endpoints regenerated after %v\n",
+		result.Mode, result.AgentsReady, result.EndpointsRegenerated)
+	var nodes []string
+	for node := range result.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		r := result.Nodes[node]
+		fmt.Printf("%s: %s ready after %v (%v after its creation), bootstrap %.1fs\n", node, r.Pod, r.Ready, r.Startup, r.Bootstrap)
+	}
+	fmt.Printf("Traffic: %s, %d reconnections\n", load, result.Reconnections)
+}
+
+// agentPods returns the names of the current agent pods.
+func agentPods(t *testing.T) map[string]bool {
+	pods, err := harness.KubeClient().CoreV1().Pods(ciliumNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: agentSelector})
+	if err != nil {
+		t.Fatal("error listing agent pods", err)
+	}
+	names := make(map[string]bool)
+	for _, pod := range pods.Items {
+		names[pod.Name] = true
+	}
+	return names
+}
+
+// deleteAgents deletes all agent pods at once.
+func deleteAgents(t *testing.T) {
+	if err := harness.KubeClient().CoreV1().Pods(ciliumNamespace).DeleteCollection(context.TODO(),
+		metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: agentSelector}); err != nil {
+		t.Fatal("error deleting agent pods", err)
+	}
+}
+
+// restartAgents triggers a rolling restart of the agent DaemonSet, the same
+// way as kubectl rollout restart.
+func restartAgents(t *testing.T) {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":%q}}}}}`,
+		time.Now().Format(time.RFC3339))
+	if _, err := harness.KubeClient().AppsV1().DaemonSets(ciliumNamespace).Patch(context.TODO(), ciliumDaemonSet,
+		types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		t.Fatal("error restarting agents", err)
+	}
+}
+
+// podReadyTime returns when pod last became ready, or false if it isn't.
+func podReadyTime(pod *corev1.Pod) (time.Time, bool) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// waitForNewAgents waits for all the pods in old to be replaced by ready agent
+// pods and the DaemonSet to be ready. It returns the recovery of each node,
+// relative to start, and the new agent pods.
+func waitForNewAgents(t *testing.T, old map[string]bool, start time.Time, timeout time.Duration) (map[string]agentRecovery, []corev1.Pod) {
+	client := harness.KubeClient()
+	var pods []corev1.Pod
+	if err := wait.Poll(2*time.Second, timeout, func() (bool, error) {
+		ds, err := client.AppsV1().DaemonSets(ciliumNamespace).Get(context.TODO(), ciliumDaemonSet, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		list, err := client.CoreV1().Pods(ciliumNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: agentSelector})
+		if err != nil {
+			return false, err
+		}
+		pods = list.Items
+		if len(pods) != int(ds.Status.DesiredNumberScheduled) {
+			return false, nil
+		}
+		for i := range pods {
+			if _, ready := podReadyTime(&pods[i]); old[pods[i].Name] || !ready {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		t.Fatal("error waiting for the agents to restart", err)
+	}
+	waitForWorkloads(t, []readiness.Object{
+		{Kind: readiness.DaemonSet, Namespace: ciliumNamespace, Name: ciliumDaemonSet},
+	}, timeout)
+
+	nodes := make(map[string]agentRecovery)
+	for i := range pods {
+		ready, _ := podReadyTime(&pods[i])
+		nodes[pods[i].Spec.NodeName] = agentRecovery{
+			Pod:     pods[i].Name,
+			Ready:   ready.Sub(start),
+			Startup: ready.Sub(pods[i].CreationTimestamp.Time),
+		}
+	}
+	return nodes, pods
+}
+
+// notReadyEndpoints returns the number of endpoints of the agent pod that
+// aren't ready, e.g. still restoring or regenerating.
+func notReadyEndpoints(config *rest.Config, pod *corev1.Pod) (int, error) {
+	// Only the standard output holds the JSON, warnings go to the
+	// standard error.
+	stdout, stderr, err := execInPod(config, pod, "cilium-agent", "cilium", "endpoint", "list", "-o", "json")
+	if err != nil {
+		return 0, fmt.Errorf("error listing endpoints of %s: %s: %s", pod.Name, err, stderr)
+	}
+	var endpoints []struct {
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(stdout, &endpoints); err != nil {
+		return 0, fmt.Errorf("error decoding endpoints of %s: %w", pod.Name, err)
+	}
+	var n int
+	for _, ep := range endpoints {
+		if ep.Status.State != "ready" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// waitForEndpointsReady waits for all the endpoints of the agent pods to be
+// ready.
+func waitForEndpointsReady(t *testing.T, pods []corev1.Pod, timeout time.Duration) {
+	config := restConfig(t)
+	pending := make(map[string]int)
+	if err := wait.Poll(2*time.Second, timeout, func() (bool, error) {
+		for i := range pods {
+			n, err := notReadyEndpoints(config, &pods[i])
+			if err != nil {
+				return false, err
+			}
+			if n == 0 {
+				delete(pending, pods[i].Name)
+			} else {
+				pending[pods[i].Name] = n
+			}
+		}
+		return len(pending) == 0, nil
+	}); err != nil {
+		t.Fatalf("error waiting for endpoints to regenerate, not ready by agent %v: %s", pending, err)
+	}
+}
+
+// queryBootstrap sets the bootstrap duration the agents reported on the nodes
+// of recoveries, waiting for the new agents to be scraped.
+func queryBootstrap(t *testing.T, base string, recoveries map[string]agentRecovery) {
+	promv1api := newPrometheusAPI(t, base)
+	q, err := ciliumMetrics().ByPod(metrics.AgentBootstrap, monitoring(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podLabel := model.LabelName(monitoring().TargetPodLabel)
+
+	bootstrap := make(map[string]float64)
+	if err := wait.Poll(5*time.Second, 2*time.Minute, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		result, _, err := promv1api.Query(ctx, q, time.Now())
+		if err != nil {
+			return false, err
+		}
+		vector, ok := result.(model.Vector)
+		if !ok {
+			return false, fmt.Errorf("unexpected result type %s for query %q", result.Type(), q)
+		}
+		for _, sample := range vector {
+			bootstrap[string(sample.Metric[podLabel])] = float64(sample.Value)
+		}
+		for _, r := range recoveries {
+			if _, ok := bootstrap[r.Pod]; !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	}); err != nil {
+		// Report the recovery without the bootstrap durations rather than
+		// failing the test case.
+		t.Logf("bootstrap duration of the new agents not scraped: %s", err)
+	}
+
+	for node, r := range recoveries {
+		r.Bootstrap = bootstrap[r.Pod]
+		recoveries[node] = r
+	}
+}
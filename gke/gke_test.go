@@ -0,0 +1,638 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	"github.com/cilium/cilium-perf-test/internal/versions"
+	"github.com/cilium/cilium-perf-test/internal/yamldoc"
+	kt "github.com/dlespiau/kube-test-harness"
+	"github.com/dlespiau/kube-test-harness/logger"
+	prometheusapi "github.com/prometheus/client_golang/api"
+	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	// auth provider for GCP, enables the client to authenticate with GKE without external
+	// dependencies (e.g. gcloud CLI)
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+)
+
+var (
+	ciliumNamespace           string
+	ciliumMonitoringNamespace string
+	prometheusServiceName     string
+	shouldDeployCilium        bool
+	duration                  time.Duration
+	ciliumVersionName         string
+	manifestPath              string
+	sharedManifestPath        string
+
+	// ciliumVersion is the registry entry of the Cilium version under test.
+	ciliumVersion *versions.Version
+
+	harness *kt.Harness
+)
+
+func init() {
+	flag.StringVar(&ciliumNamespace, "ns", "cilium-perf", "namespace that Cilium is in")
+	flag.StringVar(&ciliumMonitoringNamespace, "prom-ns", "cilium-monitoring", "namespace with prom pods")
+	flag.StringVar(&prometheusServiceName, "prom-name", "prometheus", "prom svc name")
+	flag.BoolVar(&shouldDeployCilium, "deploy-cilium", false, "set to false if Cilium is already deployed")
+	flag.DurationVar(&duration, "duration", 7*time.Minute, "test duration")
+	flag.StringVar(&ciliumVersionName, "cilium-version", "1.8", fmt.Sprintf("Cilium version to test, one of %v", versions.Names()))
+	flag.StringVar(&manifestPath, "manifest-path", "../manifests", "path that the per Cilium version manifests are in")
+	flag.StringVar(&sharedManifestPath, "shared-manifest-path", "../shared", "path that Cilium version agnostic manifests are in")
+}
+
+type TestCase struct {
+	name      string
+	manifests []string
+	// transforms are applied to the objects of manifests before deploying them.
+	transforms []objectTransform
+	// metrics are queried in addition to the default set of agent metrics.
+	metrics []metrics.Name
+	// run drives the workload for the test duration and adds its results to
+	// report. When nil, the cluster is left running idle for the test duration.
+	run func(t *testing.T, test *kt.Test, report *caseReport)
+}
+
+// workaround that allows additional flags
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	var err error
+	if ciliumVersion, err = versions.Get(ciliumVersionName); err != nil {
+		log.Fatal(err)
+	}
+	upgradeToVersion = ciliumVersion
+	if upgradeToVersionName != "" {
+		if upgradeToVersion, err = versions.Get(upgradeToVersionName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := loadImageMapping(); err != nil {
+		log.Fatal(err)
+	}
+
+	harness = kt.New(kt.Options{
+		LogLevel: logger.Debug,
+	})
+	if err := harness.Setup(); err != nil {
+		log.Fatal(err)
+	}
+	// h.Setup() above already calls SetKubeconfig, but doesn't handle errors, so we might end
+	// up with a harness without a k8s client. So call it to avoid that.
+	if err := harness.SetKubeconfig(""); err != nil {
+		log.Fatal(err)
+	}
+	if err := openLedger(); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	teardownObjects()
+	os.Exit(code)
+}
+
+// setupCluster deploys Cilium and the monitoring stack, when requested.
+func setupCluster(t *testing.T) {
+	test := harness.NewTest(t)
+	test.Setup()
+	recordNamespace(t, test.Namespace)
+	defer collectDiagnostics(t, "", "setup")
+
+	checkPreconditions(t, test, ciliumNamespace)
+	createCiliumFlowSchema(t, ciliumNamespace)
+
+	if prePull {
+		prePullImages(t, test)
+	}
+	if shouldDeployCilium {
+		deployCilium(t, test, ciliumNamespace)
+		deployMonitoring(t, test)
+		exposePrometheus(t, test)
+	}
+	if collectNodeStats {
+		deployNodeStats(t, test)
+	}
+	test.Close()
+}
+
+func TestCases(t *testing.T) {
+	if soakDuration > 0 {
+		t.Skip("soak mode, only running TestSoak")
+	}
+	setupCluster(t)
+
+	tests := []TestCase{
+		{name: "baseline", manifests: []string{}},
+		{name: "small-load", manifests: []string{path.Join(sharedManifestPath, "abchain.yaml")}},
+		{name: "big-load", manifests: []string{path.Join(sharedManifestPath, "abchain-big.yaml")}},
+		{
+			name:      "endpoint-churn",
+			manifests: []string{path.Join(sharedManifestPath, "churn.yaml")},
+			metrics: []metrics.Name{
+				metrics.Endpoints,
+				metrics.IPAddresses,
+			},
+			run: runChurn,
+		},
+		{
+			name: "service-scale",
+			metrics: []metrics.Name{
+				metrics.ServicesAdded,
+			},
+			run: runServiceScale,
+		},
+		{
+			name:      "identity-churn",
+			manifests: []string{path.Join(sharedManifestPath, "identity-churn.yaml")},
+			metrics: []metrics.Name{
+				metrics.PolicyRegenerations,
+			},
+			run: runIdentityChurn,
+		},
+		{
+			name: "l7-proxy-overhead",
+			metrics: []metrics.Name{
+				metrics.ProxyUpstreamReplyP99,
+			},
+			run: runL7Overhead,
+		},
+		{
+			name:       "saturation",
+			manifests:  []string{path.Join(sharedManifestPath, "abchain.yaml")},
+			transforms: []objectTransform{withoutABChainWaits},
+			run:        runSaturation,
+		},
+	}
+	for _, users := range boutiqueUsers(t) {
+		tests = append(tests, TestCase{
+			name:      fmt.Sprintf("boutique-%d", users),
+			manifests: []string{path.Join(sharedManifestPath, "boutique.yaml")},
+			transforms: []objectTransform{
+				withLoadGeneratorUsers(users),
+				withLoadGeneratorRunTime(duration),
+			},
+			run: runBoutique,
+		})
+	}
+	if disruptionMode != "" {
+		// After the other cases, so that they don't measure agents that
+		// just restarted.
+		tests = append(tests, TestCase{
+			name:      "agent-disruption",
+			manifests: []string{path.Join(sharedManifestPath, "abchain.yaml")},
+			metrics: []metrics.Name{
+				metrics.Endpoints,
+			},
+			run: runAgentDisruption,
+		})
+	}
+	if upgradeTo != "" {
+		// Last, as it leaves the cluster with the manifest the test case
+		// started from.
+		tests = append(tests, TestCase{
+			name:      "cilium-upgrade",
+			manifests: []string{path.Join(sharedManifestPath, "abchain.yaml")},
+			metrics: []metrics.Name{
+				metrics.EndpointRegenerations,
+			},
+			run: runUpgrade,
+		})
+	}
+
+	report := newRunReport()
+	defer report.write(t)
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			test := harness.NewTest(t)
+			test.Setup()
+			recordNamespace(t, test.Namespace)
+			defer test.Close()
+			defer collectDiagnostics(t, test.Namespace, testCase.name)
+
+			caseReport := report.newCase(testCase.name)
+
+			tracker := startPodStartupTracker(test.Namespace)
+			defer tracker.stop()
+			// Reported even when the case fails, when it matters most.
+			defer tracker.report(caseReport)
+
+			for _, manifest := range testCase.manifests {
+				workloads := deployManifest(t, test, manifest, test.Namespace, testCase.transforms...)
+				waitForWorkloads(t, workloads, 5*time.Minute)
+			}
+
+			profiler := startAgentProfiler(t, testCase.name)
+			defer profiler.stop()
+			nodeStats := startNodeStats(t)
+			apiserverLoad := startAPIServerLoad(t)
+
+			if testCase.run != nil {
+				testCase.run(t, test, caseReport)
+			} else {
+				log.Printf("Letting the cluster run for %v to gather metrics...", duration)
+				<-time.After(duration)
+			}
+			nodeStats.report(t, caseReport)
+			apiserverLoad.report(t, caseReport)
+			promURL := prometheusURL(t, test)
+			queryMetrics(t, promURL, duration, testCase.metrics, caseReport)
+			queryContainerMetrics(t, promURL, duration, test.Namespace, caseReport)
+			queryNodeBreakdown(t, promURL, duration, testCase.metrics, test.Namespace, caseReport)
+		})
+	}
+}
+
+func checkPreconditions(t *testing.T, test *kt.Test, namespace string) {
+	if namespace == "kube-system" {
+		t.Fatal("Cilium won't run in kube-system namespace on GKE.")
+	}
+}
+
+// loadYAML returns the YAML documents of manifest, leaving out the ones
+// without an object, e.g. license headers made of comments only.
+func loadYAML(t *testing.T, manifest string) [][]byte {
+	f, err := os.Open(manifest)
+	if err != nil {
+		t.Fatalf("failed to open manifest %q: %s", manifest, err)
+	}
+	defer f.Close()
+
+	docs, err := yamldoc.Read(f)
+	if err != nil {
+		t.Fatalf("%s: %s", manifest, err)
+	}
+	return docs
+}
+
+// objectTransform modifies a k8s object decoded from a manifest before it is
+// deployed.
+type objectTransform func(obj runtime.Object)
+
+// deployManifest deploys all k8s objects defined in the file manifest to
+// namespace, after applying transforms to them. It returns the workloads it
+// deployed.
+func deployManifest(t *testing.T, test *kt.Test, manifest, namespace string, transforms ...objectTransform) []readiness.Object {
+	var workloads []readiness.Object
+	docs := loadYAML(t, manifest)
+	for _, d := range docs {
+		if len(d) < 2 {
+			continue
+		}
+
+		obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(d, nil, nil)
+		if runtime.IsNotRegisteredError(err) {
+			// Not a core k8s resource, it may be a Cilium one.
+			createCiliumObject(t, d, namespace)
+			continue
+		}
+		if err != nil {
+			t.Log(d)
+			t.Fatalf("failed to decode: %s", err)
+		}
+
+		for _, transform := range transforms {
+			transform(obj)
+		}
+		withImageMapping(obj)
+
+		objNamespace := namespace
+		// The kinds handled here are listed in validate.SupportedKinds,
+		// update it along with them.
+		switch obj.(type) {
+		case *rbacv1.ClusterRole:
+			cr := obj.(*rbacv1.ClusterRole)
+			test.CreateClusterRole(cr)
+			objNamespace = ""
+		case *rbacv1.ClusterRoleBinding:
+			crb := obj.(*rbacv1.ClusterRoleBinding)
+			test.CreateClusterRoleBinding(crb)
+			objNamespace = ""
+		case *corev1.ConfigMap:
+			cm := obj.(*corev1.ConfigMap)
+			test.CreateConfigMap(namespace, cm)
+		case *appsv1.DaemonSet:
+			ds := obj.(*appsv1.DaemonSet)
+			test.CreateDaemonSet(namespace, ds)
+		case *appsv1.Deployment:
+			d := obj.(*appsv1.Deployment)
+			test.CreateDeployment(namespace, d)
+		case *appsv1.StatefulSet:
+			ss := obj.(*appsv1.StatefulSet)
+			ss.Namespace = namespace
+			if _, err := harness.KubeClient().AppsV1().StatefulSets(namespace).Create(context.TODO(), ss, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("failed to create statefulset %s: %s", ss.Name, err)
+			}
+		case *batchv1.Job:
+			j := obj.(*batchv1.Job)
+			j.Namespace = namespace
+			if _, err := harness.KubeClient().BatchV1().Jobs(namespace).Create(context.TODO(), j, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("failed to create job %s: %s", j.Name, err)
+			}
+		case *corev1.Namespace:
+			n := obj.(*corev1.Namespace)
+			test.CreateNamespace(n.Name)
+			objNamespace = ""
+		case *corev1.Service:
+			s := obj.(*corev1.Service)
+			test.CreateService(namespace, s)
+		case *corev1.ServiceAccount:
+			sa := obj.(*corev1.ServiceAccount)
+			test.CreateServiceAccount(namespace, sa)
+		default:
+			t.Fatalf("k8s resource %T not handled", obj)
+		}
+
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordObject(t, *gvk, objNamespace, accessor.GetName())
+
+		if workload, ok := readiness.Workload(obj, namespace); ok {
+			workloads = append(workloads, workload)
+		}
+	}
+	return workloads
+}
+
+// waitForWorkloads waits for workloads to be ready, failing the test with the
+// workloads that are stuck after timeout.
+func waitForWorkloads(t *testing.T, workloads []readiness.Object, timeout time.Duration) {
+	if err := readiness.Wait(harness.KubeClient(), workloads, timeout); err != nil {
+		t.Fatal("error waiting for workloads: ", err)
+	}
+}
+
+// ciliumTransforms returns the transforms applied to the Cilium manifests of
+// version.
+func ciliumTransforms(version *versions.Version) []objectTransform {
+	var transforms []objectTransform
+	if profileInterval > 0 {
+		transforms = append(transforms, withAgentPprof(version))
+	}
+	return transforms
+}
+
+// createNamespace creates the namespace name, unless it exists. Unlike
+// test.CreateNamespace, the namespace outlives test, it is deleted on teardown.
+func createNamespace(t *testing.T, name string) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	_, err := harness.KubeClient().CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("failed to create namespace %s: %s", name, err)
+	}
+	recordNamespace(t, name)
+}
+
+func deployCilium(t *testing.T, test *kt.Test, namespace string) {
+	createNamespace(t, namespace)
+
+	// deploy cilium kitchen sink
+	manifest, err := ciliumVersion.CiliumManifest(manifestPath, "gke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workloads := deployManifest(t, test, manifest, namespace, ciliumTransforms(ciliumVersion)...)
+
+	// ui usually takes about ~60s so give it some room
+	waitForWorkloads(t, workloads, 3*time.Minute)
+
+	if !ciliumVersion.Quirks.RestartMetricsServer {
+		return
+	}
+
+	// restart metrics-server by deleting it so that it's managed by cilium
+	metricsServerLabel := "k8s-app=metrics-server"
+	pods := test.ListPods("kube-system", metav1.ListOptions{
+		LabelSelector: metricsServerLabel,
+	})
+	for _, p := range pods.Items {
+		t.Logf("Deleting pod %s matching label %s", p.Name, metricsServerLabel)
+		test.DeletePod(&p)
+	}
+
+	// wait for the metrics-server deployment, its name depends on the GKE
+	// version
+	deployments, err := harness.KubeClient().AppsV1().Deployments("kube-system").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: metricsServerLabel,
+	})
+	if err != nil {
+		t.Fatal("error listing metrics-server deployments", err)
+	}
+	workloads = nil
+	for i := range deployments.Items {
+		if workload, ok := readiness.Workload(&deployments.Items[i], "kube-system"); ok {
+			workloads = append(workloads, workload)
+		}
+	}
+	waitForWorkloads(t, workloads, 2*time.Minute)
+}
+
+func deployMonitoring(t *testing.T, test *kt.Test) {
+	workloads := deployManifest(t, test, ciliumVersion.Manifest(manifestPath, ciliumVersion.MonitoringManifest), ciliumMonitoringNamespace)
+	waitForWorkloads(t, workloads, 3*time.Minute)
+}
+
+func exposePrometheus(t *testing.T, test *kt.Test) {
+	docs := loadYAML(t, ciliumVersion.Manifest(manifestPath, ciliumVersion.ExposePrometheusManifest))
+	for _, d := range docs {
+		if len(d) < 2 {
+			continue
+		}
+
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(d, nil, nil)
+		if err != nil {
+			t.Log(d)
+			t.Fatalf("failed to decode: %s", err)
+		}
+
+		switch obj.(type) {
+		case *corev1.Service:
+			newSvc := obj.(*corev1.Service)
+			svc := test.GetService(ciliumMonitoringNamespace, newSvc.Name)
+			// TODO: for now this just hard-codes the fields the expose-prometheus.xml
+			// manifest specifies.
+			svc.Spec.Ports = newSvc.Spec.Ports
+			svc.Spec.Selector = newSvc.Spec.Selector
+			svc.Spec.Type = newSvc.Spec.Type
+			test.UpdateService(svc)
+		default:
+			t.Fatalf("k8s resource %T not handled", obj)
+		}
+	}
+}
+
+// ciliumMetrics returns the metrics of the Cilium versions deployed by the run,
+// -cilium-version and -upgrade-to-version, matching the series of both where
+// they are named differently.
+func ciliumMetrics() metrics.Set {
+	return metrics.Union(ciliumVersion.Metrics, upgradeToVersion.Metrics)
+}
+
+// monitoring returns the labels of the series scraped by the Prometheus server
+// of the Cilium version under test.
+func monitoring() metrics.Monitoring {
+	m := ciliumVersion.Monitoring
+	if gkeClusterName := os.Getenv("CLUSTER_NAME"); gkeClusterName != "" {
+		m.Matchers = append(append([]string(nil), m.Matchers...), fmt.Sprintf(`test_cluster_name="%s"`, gkeClusterName))
+	}
+	return m
+}
+
+func newPrometheusAPI(t *testing.T, base string) prometheusv1.API {
+	client, err := prometheusapi.NewClient(prometheusapi.Config{
+		Address: base,
+	})
+	if err != nil {
+		t.Fatal("error creating prometheus client", err)
+	}
+	return prometheusv1.NewAPI(client)
+}
+
+// queryScalar evaluates query at the current time and returns the value of the
+// first sample of the result, or 0 if the result is empty.
+func queryScalar(t *testing.T, promv1api prometheusv1.API, query string) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, _, err := promv1api.Query(ctx, query, time.Now())
+	if err != nil {
+		t.Fatal("error querying Prometheus", err)
+	}
+	vector, ok := result.(model.Vector)
+	if !ok {
+		t.Fatalf("unexpected result type %s for query %q", result.Type(), query)
+	}
+	if len(vector) == 0 {
+		return 0
+	}
+	return float64(vector[0].Value)
+}
+
+// queryMetric returns the logical metric name aggregated across agent or
+// operator pods with op, e.g. "sum", at the current time. Rates are computed
+// over window.
+func queryMetric(t *testing.T, promv1api prometheusv1.API, op string, name metrics.Name, window time.Duration) float64 {
+	q, err := ciliumMetrics().Aggregate(op, name, monitoring(), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queryScalar(t, promv1api, q)
+}
+
+// defaultMetrics are the logical metrics queried for every test case.
+var defaultMetrics = []metrics.Name{
+	metrics.AgentCPU,
+	metrics.AgentVirtualMemory,
+	metrics.AgentResidentMemory,
+	metrics.AgentBootstrap,
+	metrics.BPFMapsMemory,
+	metrics.BPFProgsMemory,
+	metrics.EndpointRegenerationP99,
+	metrics.PolicyRegenerationP99,
+	metrics.OperatorCPU,
+	metrics.OperatorResidentMemory,
+	metrics.OperatorGoroutines,
+	metrics.OperatorIdentityGCEntries,
+	metrics.OperatorIdentityGCRuns,
+}
+
+func queryMetrics(t *testing.T, base string, duration time.Duration, extraMetrics []metrics.Name, report *caseReport) {
+	promv1api := newPrometheusAPI(t, base)
+	r := prometheusv1.Range{
+		Start: time.Now().Add(-duration),
+		End:   time.Now(),
+		Step:  time.Minute,
+	}
+
+	names := append(append([]metrics.Name(nil), defaultMetrics...), extraMetrics...)
+
+	results := make(map[string]model.Value)
+	defer report.add("metrics", results)
+
+	fmt.Printf("Results:\n")
+	for _, name := range names {
+		for _, op := range []string{"min", "max", "avg"} {
+			fn, err := ciliumMetrics().Aggregate(op, name, monitoring(), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Each query gets its own timeout, the queries of the
+			// metrics listed last would otherwise get whatever time
+			// the first ones left.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			result, _, err := promv1api.QueryRange(
+				ctx,
+				fn,
+				r,
+			)
+			cancel()
+			if err != nil {
+				t.Fatal("error querying Prometheus", err)
+			}
+			fmt.Printf("%s %s (%s):\n%v\n", op, name, fn, result)
+			// Results are keyed by logical metric so that they can be
+			// compared across Cilium versions.
+			results[op+"("+string(name)+")"] = result
+		}
+	}
+}
+
+// prometheusURL returns the address at which the Prometheus server collecting
+// the Cilium metrics can be reached.
+func prometheusURL(t *testing.T, test *kt.Test) string {
+	if shouldDeployCilium {
+		return getPrometheusURL(t, test)
+	}
+	return fmt.Sprintf("http://%s.%s.svc", prometheusServiceName, ciliumMonitoringNamespace)
+}
+
+func getPrometheusURL(t *testing.T, test *kt.Test) string {
+	svc := test.GetService(ciliumMonitoringNamespace, prometheusServiceName)
+	nodePort := svc.Spec.Ports[0].NodePort
+
+	var nodes *corev1.NodeList
+	if nodes = test.ListNodes(metav1.ListOptions{}); nodes == nil {
+		t.Fatal("error listing nodes")
+	}
+
+	var externalIP string
+	for _, n := range nodes.Items {
+		for _, ip := range n.Status.Addresses {
+			if ip.Type == corev1.NodeExternalIP {
+				externalIP = ip.Address
+				break
+			}
+		}
+	}
+
+	if externalIP == "" {
+		t.Fatal("could not find node external IP")
+	}
+
+	return fmt.Sprintf("http://%s:%d", externalIP, nodePort)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/latency"
+	kt "github.com/dlespiau/kube-test-harness"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	identityChurnDeployment = "identity-churn"
+	identityChurnLabel      = "identity-churn-generation"
+)
+
+var (
+	identityChurnInterval time.Duration
+	identityGCTimeout     time.Duration
+)
+
+func init() {
+	flag.DurationVar(&identityChurnInterval, "identity-churn-interval", 30*time.Second, "time between two relabelings of the identity churn pods and namespace")
+	// The operator only collects identities that haven't been used for
+	// identity-heartbeat-timeout (30m), every identity-gc-interval (15m).
+	flag.DurationVar(&identityGCTimeout, "identity-gc-timeout", 45*time.Minute, "time to wait for identities to be garbage collected after the identity churn test, 0 to skip the check")
+}
+
+// namespaceLabel returns the identity label of the namespace label key=value.
+func namespaceLabel(key, value string) string {
+	return fmt.Sprintf("k8s:io.cilium.k8s.namespace.labels.%s=%s", key, value)
+}
+
+// podLabel returns the identity label of the pod label key=value.
+func podLabel(key, value string) string {
+	return fmt.Sprintf("k8s:%s=%s", key, value)
+}
+
+// identityChurnReport holds the results of the identity churn test.
+type identityChurnReport struct {
+	// Baseline, Peak and Final are the numbers of CiliumIdentities before,
+	// at most during and at the end of the relabelings.
+	Baseline int `json:"baseline"`
+	Peak     int `json:"peak"`
+	Final    int `json:"final"`
+	// NamespaceLatency and PodLatency are the distributions of the time it
+	// took for the endpoints to get a new identity after relabeling their
+	// namespace and their pods.
+	NamespaceLatency latency.Distribution `json:"namespace_latency"`
+	PodLatency       latency.Distribution `json:"pod_latency"`
+	// GarbageCollected is the number of CiliumIdentities left once the
+	// unused ones were garbage collected, if checked.
+	GarbageCollected *int `json:"garbage_collected,omitempty"`
+}
+
+// runIdentityChurn relabels the namespace of the identity churn deployment,
+// then its pods, every identityChurnInterval for the whole test duration,
+// forcing Cilium to allocate a new security identity for the pods after each
+// relabeling. It reports how long the pods took to get their new identity and
+// how many identities existed, then checks the unused identities are garbage
+// collected.
+func runIdentityChurn(t *testing.T, test *kt.Test, report *caseReport) {
+	baseline := countCiliumObjects(t, "ciliumidentities")
+	log.Printf("Relabeling namespace and pods of deployment %s every %v, baseline is %d identities",
+		identityChurnDeployment, identityChurnInterval, baseline)
+
+	ticker := time.NewTicker(identityChurnInterval)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	var namespaceLatencies, podLatencies []time.Duration
+	peak := baseline
+	for generation, done := 1, false; !done; generation++ {
+		value := strconv.Itoa(generation)
+		if latency, ok := waitForNewIdentities(t, test.Namespace, namespaceLabel(identityChurnLabel, value), func() {
+			relabelNamespace(t, test.Namespace, value)
+		}); ok {
+			namespaceLatencies = append(namespaceLatencies, latency)
+		} else {
+			t.Errorf("endpoints did not get a new identity within %v of relabeling namespace %s", identityChurnInterval, test.Namespace)
+		}
+		if latency, ok := waitForNewIdentities(t, test.Namespace, podLabel(identityChurnLabel, value), func() {
+			relabelPods(t, test.Namespace, value)
+		}); ok {
+			podLatencies = append(podLatencies, latency)
+		} else {
+			t.Errorf("endpoints did not get a new identity within %v of relabeling their pods", identityChurnInterval)
+		}
+
+		if count := countCiliumObjects(t, "ciliumidentities"); count > peak {
+			peak = count
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			done = true
+		}
+	}
+
+	latency.Sort(namespaceLatencies)
+	latency.Sort(podLatencies)
+	result := &identityChurnReport{
+		Baseline:         baseline,
+		Peak:             peak,
+		Final:            countCiliumObjects(t, "ciliumidentities"),
+		NamespaceLatency: latency.Summarize(namespaceLatencies),
+		PodLatency:       latency.Summarize(podLatencies),
+	}
+	defer report.add("identities", result)
+
+	fmt.Printf("Identities: baseline %d, peak %d, current %d\n", result.Baseline, result.Peak, result.Final)
+	printIdentityLatencies("namespace", result.NamespaceLatency)
+	printIdentityLatencies("pod", result.PodLatency)
+
+	if identityGCTimeout == 0 {
+		return
+	}
+
+	// Removing the pods leaves every identity allocated by the test unused.
+	scaleDeployment(t, test.Namespace, identityChurnDeployment, 0)
+	log.Printf("Waiting up to %v for identities to be garbage collected...", identityGCTimeout)
+	var count int
+	if err := wait.Poll(time.Minute, identityGCTimeout, func() (bool, error) {
+		count = countCiliumObjects(t, "ciliumidentities")
+		return count <= baseline, nil
+	}); err != nil {
+		t.Errorf("identities were not garbage collected: got %d, want at most %d", count, baseline)
+	}
+	result.GarbageCollected = &count
+}
+
+// printIdentityLatencies prints the distribution of the identity allocation
+// latencies after relabeling what.
+func printIdentityLatencies(what string, d latency.Distribution) {
+	if d.Count == 0 {
+		return
+	}
+	fmt.Printf("Identity allocation latency (%d %s relabelings): %s\n", d.Count, what, d)
+}
+
+// waitForNewIdentities calls relabel and waits up to identityChurnInterval for
+// all the endpoints of namespace to get an identity other than the one they
+// had before, with label. It returns how long it took and false on timeout.
+func waitForNewIdentities(t *testing.T, namespace, label string, relabel func()) (time.Duration, bool) {
+	before := make(map[string]int64)
+	for _, ep := range listCiliumEndpoints(t, namespace) {
+		before[ep.Metadata.Name] = ep.Status.Identity.ID
+	}
+
+	start := time.Now()
+	relabel()
+	err := wait.Poll(time.Second, identityChurnInterval, func() (bool, error) {
+		return endpointsHaveNewIdentity(t, namespace, label, before), nil
+	})
+	return time.Since(start), err == nil
+}
+
+// relabelNamespace sets the identity churn label of namespace to value.
+func relabelNamespace(t *testing.T, namespace, value string) {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, identityChurnLabel, value))
+	if _, err := harness.KubeClient().CoreV1().Namespaces().Patch(context.TODO(), namespace, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		t.Fatalf("failed to relabel namespace %s: %s", namespace, err)
+	}
+}
+
+// relabelPods sets the identity churn label of the pods of namespace to value.
+func relabelPods(t *testing.T, namespace, value string) {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, identityChurnLabel, value))
+	pods := harness.KubeClient().CoreV1().Pods(namespace)
+	list, err := pods.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal("error listing pods", err)
+	}
+	for _, p := range list.Items {
+		if _, err := pods.Patch(context.TODO(), p.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			t.Fatalf("failed to relabel pod %s: %s", p.Name, err)
+		}
+	}
+}
+
+// endpointsHaveNewIdentity returns whether all the endpoints of namespace have
+// an identity with label, other than their identity in before.
+func endpointsHaveNewIdentity(t *testing.T, namespace, label string, before map[string]int64) bool {
+	endpoints := listCiliumEndpoints(t, namespace)
+	if len(endpoints) == 0 {
+		return false
+	}
+	for _, ep := range endpoints {
+		if id, ok := before[ep.Metadata.Name]; ok && id == ep.Status.Identity.ID {
+			return false
+		}
+		found := false
+		for _, l := range ep.Status.Identity.Labels {
+			if l == label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
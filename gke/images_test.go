@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/images"
+	kt "github.com/dlespiau/kube-test-harness"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	imageMapPath string
+	prePull      bool
+
+	// imageMapping rewrites the images of the deployed objects, it is nil
+	// when -image-map isn't set.
+	imageMapping *images.Mapping
+)
+
+func init() {
+	flag.StringVar(&imageMapPath, "image-map", "", "YAML file mapping the images of the manifests to the ones to deploy, e.g. from a local registry")
+	flag.BoolVar(&prePull, "prepull", false, "pull the images of all the manifests on every node before running the tests")
+}
+
+// loadImageMapping loads the image mapping of -image-map, if set.
+func loadImageMapping() error {
+	if imageMapPath == "" {
+		return nil
+	}
+	var err error
+	imageMapping, err = images.Load(imageMapPath)
+	return err
+}
+
+// withImageMapping rewrites the images of the pod template of obj according to
+// imageMapping.
+func withImageMapping(obj runtime.Object) {
+	imageMapping.Object(obj)
+}
+
+// testImages returns the images the tests may deploy, once rewritten.
+func testImages(t *testing.T) []string {
+	manifests := []string{
+		ciliumVersion.Manifest(manifestPath, ciliumVersion.MonitoringManifest),
+	}
+	if m, err := ciliumVersion.CiliumManifest(manifestPath, "gke"); err == nil {
+		manifests = append(manifests, m)
+	}
+	if upgradeTo != "" {
+		manifests = append(manifests, upgradeTo)
+	}
+	shared, err := filepath.Glob(filepath.Join(sharedManifestPath, "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests = append(manifests, shared...)
+
+	seen := map[string]bool{imageMapping.Rewrite(fortioImage): true}
+	for _, manifest := range manifests {
+		f, err := os.Open(manifest)
+		if err != nil {
+			t.Fatalf("failed to open manifest %q: %s", manifest, err)
+		}
+		list, err := images.List(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to read manifest %q: %s", manifest, err)
+		}
+		for _, image := range list {
+			seen[imageMapping.Rewrite(image)] = true
+		}
+	}
+
+	var list []string
+	for image := range seen {
+		list = append(list, image)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// prePullImages pulls the images the tests may deploy on every node, so that
+// pulling them isn't accounted in the measurements of the test cases.
+func prePullImages(t *testing.T, test *kt.Test) {
+	list := testImages(t)
+	log.Printf("Pre-pulling %d images on every node...", len(list))
+	var pullPolicy corev1.PullPolicy
+	if imageMapping != nil {
+		pullPolicy = imageMapping.PullPolicy
+	}
+	ds := images.PrePullDaemonSet("image-prepull", list, pullPolicy)
+	test.CreateDaemonSet(test.Namespace, ds)
+	defer func() {
+		if err := harness.KubeClient().AppsV1().DaemonSets(test.Namespace).Delete(context.TODO(), ds.Name, metav1.DeleteOptions{}); err != nil {
+			t.Logf("failed to delete DaemonSet %s: %s", ds.Name, err)
+		}
+	}()
+
+	if err := images.WaitPrePull(harness.KubeClient(), test.Namespace, ds.Name, 20*time.Minute); err != nil {
+		t.Fatal("error pre-pulling images: ", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	kt "github.com/dlespiau/kube-test-harness"
+	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const proxyVisibilityAnnotation = "io.cilium.proxy-visibility"
+
+// l7Variants are the variants of the abchain workload compared by the L7 proxy
+// overhead test. The first one doesn't go through the proxy and is the
+// reference the others are compared to.
+var l7Variants = []struct {
+	name      string
+	manifests []string
+	transform objectTransform
+}{
+	{
+		name:      "no-proxy",
+		manifests: []string{"abchain.yaml"},
+		transform: withoutProxyVisibility,
+	},
+	{
+		name:      "visibility",
+		manifests: []string{"abchain.yaml"},
+	},
+	{
+		name:      "l7-policy",
+		manifests: []string{"abchain.yaml", "abchain-l7-policy.yaml"},
+		transform: withoutProxyVisibility,
+	},
+}
+
+// withoutProxyVisibility removes the L7 proxy visibility annotation from the
+// pods of a deployment.
+func withoutProxyVisibility(obj runtime.Object) {
+	if d, ok := obj.(*appsv1.Deployment); ok {
+		delete(d.Spec.Template.Annotations, proxyVisibilityAnnotation)
+	}
+}
+
+// agentUsage is the average resource usage of the Cilium agents over a period
+// of time, summed across nodes.
+type agentUsage struct {
+	// agentCPU is the CPU used by the cilium-agent process, in cores.
+	agentCPU float64
+	// containerCPU is the CPU used by the cilium-agent container, which also
+	// runs the Envoy proxy, in cores.
+	containerCPU float64
+	// agentMemory is the resident memory of the cilium-agent process, in bytes.
+	agentMemory float64
+	// containerMemory is the working set of the cilium-agent container, in
+	// bytes.
+	containerMemory float64
+}
+
+// proxyCPU returns the CPU used by the proxy, in cores.
+func (u agentUsage) proxyCPU() float64 {
+	return u.containerCPU - u.agentCPU
+}
+
+// measureAgentUsage returns the agent resource usage over the last window.
+func measureAgentUsage(t *testing.T, promv1api prometheusv1.API, window time.Duration) agentUsage {
+	avg := func(name metrics.Name) float64 {
+		q, err := ciliumMetrics().Query(name, monitoring(), window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return queryScalar(t, promv1api, fmt.Sprintf("sum(avg_over_time((%s)[%ds:]))", q, int(window.Seconds())))
+	}
+	return agentUsage{
+		agentCPU:        queryMetric(t, promv1api, "sum", metrics.AgentCPU, window),
+		containerCPU:    queryMetric(t, promv1api, "sum", metrics.AgentContainerCPU, window),
+		agentMemory:     avg(metrics.AgentResidentMemory),
+		containerMemory: avg(metrics.AgentContainerMemory),
+	}
+}
+
+// l7Usage is the latency and resource usage of a variant of the L7 proxy
+// overhead test.
+type l7Usage struct {
+	P99 time.Duration `json:"p99"`
+	// AgentCPU and ProxyCPU are in cores, summed across nodes.
+	AgentCPU float64 `json:"agent_cpu"`
+	ProxyCPU float64 `json:"proxy_cpu"`
+	// AgentMemory and ContainerMemory are in bytes, summed across nodes.
+	AgentMemory     float64 `json:"agent_memory"`
+	ContainerMemory float64 `json:"container_memory"`
+}
+
+func newL7Usage(load *loadResult, usage agentUsage) l7Usage {
+	return l7Usage{
+		P99:             load.percentile(99),
+		AgentCPU:        usage.agentCPU,
+		ProxyCPU:        usage.proxyCPU(),
+		AgentMemory:     usage.agentMemory,
+		ContainerMemory: usage.containerMemory,
+	}
+}
+
+// sub returns the difference between u and reference.
+func (u l7Usage) sub(reference l7Usage) l7Usage {
+	return l7Usage{
+		P99:             u.P99 - reference.P99,
+		AgentCPU:        u.AgentCPU - reference.AgentCPU,
+		ProxyCPU:        u.ProxyCPU - reference.ProxyCPU,
+		AgentMemory:     u.AgentMemory - reference.AgentMemory,
+		ContainerMemory: u.ContainerMemory - reference.ContainerMemory,
+	}
+}
+
+// l7VariantReport holds the results of a variant of the L7 proxy overhead
+// test.
+type l7VariantReport struct {
+	Name string `json:"name"`
+	l7Usage
+	// Overhead is the difference with the variant not going through the
+	// proxy.
+	Overhead l7Usage `json:"overhead"`
+}
+
+// runL7Overhead runs the abchain workload without going through the L7 proxy,
+// with L7 visibility enabled and with an L7 HTTP policy, one after the other,
+// and reports how much latency and resource usage the proxy adds.
+func runL7Overhead(t *testing.T, test *kt.Test, report *caseReport) {
+	promv1api := newPrometheusAPI(t, prometheusURL(t, test))
+
+	var loads []*loadResult
+	var usages []l7Usage
+
+	for _, variant := range l7Variants {
+		namespace := test.Namespace + "-" + variant.name
+		test.CreateNamespace(namespace)
+		recordNamespace(t, namespace)
+
+		var transforms []objectTransform
+		if variant.transform != nil {
+			transforms = append(transforms, variant.transform)
+		}
+		var workloads []readiness.Object
+		for _, manifest := range variant.manifests {
+			workloads = append(workloads, deployManifest(t, test, path.Join(sharedManifestPath, manifest), namespace, transforms...)...)
+		}
+		waitForWorkloads(t, workloads, 5*time.Minute)
+
+		log.Printf("Running %s variant for %v...", variant.name, duration)
+		load := runLoad(t, test, namespace, "http://port-abc:3770/", loadQPS, loadConnections, duration)
+		loads = append(loads, load)
+		usages = append(usages, newL7Usage(load, measureAgentUsage(t, promv1api, duration)))
+
+		// Stop the workload so it doesn't add up to the next variant.
+		scaleDeployment(t, namespace, "abchain", 0)
+	}
+
+	var variants []l7VariantReport
+	for i, u := range usages {
+		variants = append(variants, l7VariantReport{
+			Name:     l7Variants[i].name,
+			l7Usage:  u,
+			Overhead: u.sub(usages[0]),
+		})
+	}
+	report.add("l7_overhead", variants)
+
+	fmt.Printf("L7 proxy overhead:\n")
+	for i, v := range variants {
+		fmt.Printf("%s: %s\n", v.Name, loads[i])
+		fmt.Printf("  p99 latency %v (%v), agent CPU %.3f (%+.3f) cores, proxy CPU %.3f (%+.3f) cores, agent memory %.0f (%+.0f) bytes, container memory %.0f (%+.0f) bytes\n",
+			v.P99, v.Overhead.P99,
+			v.AgentCPU, v.Overhead.AgentCPU,
+			v.ProxyCPU, v.Overhead.ProxyCPU,
+			v.AgentMemory, v.Overhead.AgentMemory,
+			v.ContainerMemory, v.Overhead.ContainerMemory,
+		)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	kt "github.com/dlespiau/kube-test-harness"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const fortioImage = "docker.io/fortio/fortio:1.11.3"
+
+var (
+	loadQPS         int
+	loadConnections int
+)
+
+func init() {
+	flag.IntVar(&loadQPS, "load-qps", 100, "requests per second sent by the in-cluster load generator")
+	flag.IntVar(&loadConnections, "load-connections", 8, "number of connections opened by the in-cluster load generator")
+}
+
+// loadResult holds the parts of the fortio JSON report the tests care about.
+type loadResult struct {
+	ActualQPS float64
+	// SocketCount is the number of connections fortio opened, including the
+	// ones reopened after an error.
+	SocketCount int
+	// RetCodes counts the responses by HTTP status code.
+	RetCodes          map[string]int64
+	DurationHistogram struct {
+		Count       int64
+		Avg         float64
+		Max         float64
+		Percentiles []struct {
+			Percentile float64
+			Value      float64
+		}
+	}
+}
+
+// percentile returns the latency of the p-th percentile of requests.
+func (r *loadResult) percentile(p float64) time.Duration {
+	for _, pct := range r.DurationHistogram.Percentiles {
+		if pct.Percentile == p {
+			return time.Duration(pct.Value * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+// errors returns the number of requests that didn't get a 200 response.
+func (r *loadResult) errors() int64 {
+	var errors int64
+	for code, count := range r.RetCodes {
+		if code != "200" {
+			errors += count
+		}
+	}
+	return errors
+}
+
+func (r *loadResult) String() string {
+	return fmt.Sprintf("%.1f qps, p50=%v p90=%v p99=%v, %d/%d errors",
+		r.ActualQPS,
+		r.percentile(50), r.percentile(90), r.percentile(99),
+		r.errors(), r.DurationHistogram.Count,
+	)
+}
+
+// runLoad runs fortio in namespace for d, sending qps requests per second to
+// url over connections connections, and returns its report.
+func runLoad(t *testing.T, test *kt.Test, namespace, url string, qps, connections int, d time.Duration) *loadResult {
+	name := startLoad(t, namespace, url, qps, connections, d)
+	return waitLoad(t, test, namespace, name, d)
+}
+
+// startLoad starts a fortio Job in namespace sending qps requests per second
+// to url over connections connections for d, and returns the name of the Job.
+func startLoad(t *testing.T, namespace, url string, qps, connections int, d time.Duration) string {
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		// Loads may start within the same second, let the apiserver pick
+		// a unique name.
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "fortio-"},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"app": "fortio"},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:  "fortio",
+						Image: fortioImage,
+						Args: []string{
+							"load",
+							"-quiet",
+							"-qps", strconv.Itoa(qps),
+							"-c", strconv.Itoa(connections),
+							"-t", d.String(),
+							"-p", "50,90,99",
+							"-json", "-",
+							url,
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	withImageMapping(job)
+
+	jobs := harness.KubeClient().BatchV1().Jobs(namespace)
+	created, err := jobs.Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal("failed to create fortio job", err)
+	}
+	return created.Name
+}
+
+// waitLoad waits for the fortio Job name started by startLoad for d to
+// complete and returns its report.
+func waitLoad(t *testing.T, test *kt.Test, namespace, name string, d time.Duration) *loadResult {
+	jobs := harness.KubeClient().BatchV1().Jobs(namespace)
+	if err := wait.Poll(5*time.Second, d+5*time.Minute, func() (bool, error) {
+		j, err := jobs.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if j.Status.Failed > 0 {
+			return false, fmt.Errorf("job %s failed", name)
+		}
+		return j.Status.Succeeded > 0, nil
+	}); err != nil {
+		t.Fatal("error waiting for load generator", err)
+	}
+
+	pods := test.ListPods(namespace, metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if len(pods.Items) == 0 {
+		t.Fatalf("no pod found for job %s", name)
+	}
+	var logs bytes.Buffer
+	if err := test.PodLogs(&logs, &pods.Items[0], "fortio"); err != nil {
+		t.Fatal("error getting load generator logs", err)
+	}
+
+	result, err := parseLoadResult(logs.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// parseLoadResult extracts the JSON report from the fortio logs, where it is
+// mixed with the fortio log messages.
+func parseLoadResult(logs string) (*loadResult, error) {
+	logs = "\n" + logs
+	start := strings.Index(logs, "\n{")
+	if start < 0 {
+		return nil, fmt.Errorf("no report found in load generator logs")
+	}
+
+	var result loadResult
+	if err := json.NewDecoder(strings.NewReader(logs[start:])).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode load generator report: %w", err)
+	}
+	return &result, nil
+}
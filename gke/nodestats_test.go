@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/nodestats"
+	kt "github.com/dlespiau/kube-test-harness"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// nodeStatsContainer is the container of the node-stats DaemonSet pods.
+const nodeStatsContainer = "node-stats"
+
+var collectNodeStats bool
+
+func init() {
+	flag.BoolVar(&collectNodeStats, "node-stats", false, "deploy a DaemonSet reading the CPU, softirq and network counters of the nodes and report their activity during each test case")
+}
+
+// deployNodeStats deploys the node-stats DaemonSet the node counters are read
+// from.
+func deployNodeStats(t *testing.T, test *kt.Test) {
+	workloads := deployManifest(t, test, path.Join(sharedManifestPath, "node-stats.yaml"), ciliumMonitoringNamespace)
+	waitForWorkloads(t, workloads, 3*time.Minute)
+}
+
+// nodeStatsSampler reads the counters of every node at the start of a test
+// case so that their activity during the test case can be reported.
+type nodeStatsSampler struct {
+	config *rest.Config
+	pods   []corev1.Pod
+	before map[string]*nodestats.Snapshot
+}
+
+// startNodeStats reads the counters of every node. It returns nil when node
+// stats aren't collected.
+func startNodeStats(t *testing.T) *nodeStatsSampler {
+	if !collectNodeStats {
+		return nil
+	}
+
+	pods, err := harness.KubeClient().CoreV1().Pods(ciliumMonitoringNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "app=node-stats",
+	})
+	if err != nil {
+		t.Fatal("error listing node-stats pods", err)
+	}
+	s := &nodeStatsSampler{
+		config: restConfig(t),
+		pods:   pods.Items,
+		before: make(map[string]*nodestats.Snapshot),
+	}
+	for i := range s.pods {
+		pod := &s.pods[i]
+		s.before[pod.Spec.NodeName] = s.snapshot(t, pod)
+	}
+	return s
+}
+
+// snapshot reads the counters of the node pod runs on.
+func (s *nodeStatsSampler) snapshot(t *testing.T, pod *corev1.Pod) *nodestats.Snapshot {
+	at := time.Now()
+	stdout, stderr, err := execInPod(s.config, pod, nodeStatsContainer, nodestats.Command...)
+	if err != nil {
+		t.Fatalf("error reading counters of node %s: %s: %s", pod.Spec.NodeName, err, stderr)
+	}
+	snapshot, err := nodestats.Parse(bytes.NewReader(stdout), at)
+	if err != nil {
+		t.Fatalf("error reading counters of node %s: %s", pod.Spec.NodeName, err)
+	}
+	return snapshot
+}
+
+// report reads the counters of every node again and adds the activity of the
+// nodes since startNodeStats to report.
+func (s *nodeStatsSampler) report(t *testing.T, report *caseReport) {
+	if s == nil {
+		return
+	}
+
+	usage := make(map[string]nodestats.Usage)
+	for i := range s.pods {
+		pod := &s.pods[i]
+		node := pod.Spec.NodeName
+		u := nodestats.Diff(s.before[node], s.snapshot(t, pod))
+		// The veths of the endpoints come and go with the pods, only keep
+		// the interfaces of the node.
+		for name := range u.Interfaces {
+			if strings.HasPrefix(name, "lxc") {
+				delete(u.Interfaces, name)
+			}
+		}
+		usage[node] = u
+	}
+	report.add("node_stats", usage)
+
+	var nodes []string
+	for node := range usage {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	fmt.Printf("Node activity:\n")
+	for _, node := range nodes {
+		u := usage[node]
+		fmt.Printf("%s: %.3f softirq cores, %.3f system cores, %.3f user cores, %d NET_RX and %d NET_TX softirqs, %d/%d TCP segments retransmitted\n",
+			node, u.CPUCores["softirq"], u.CPUCores["system"], u.CPUCores["user"],
+			u.Softirqs["NET_RX"], u.Softirqs["NET_TX"], u.TCP.RetransSegs, u.TCP.OutSegs)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/latency"
+	"github.com/cilium/cilium-perf-test/internal/podstartup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podStartupTracker watches the pods created in a namespace and the events
+// about them to record how long each pod took to start.
+type podStartupTracker struct {
+	since time.Time
+	stopC chan struct{}
+
+	mu   sync.Mutex
+	pods map[types.UID]*podstartup.Pod
+}
+
+// startPodStartupTracker starts tracking the startup of the pods created in
+// namespace from now on. Call stop once the pods are ready.
+func startPodStartupTracker(namespace string) *podStartupTracker {
+	tracker := &podStartupTracker{
+		// creation timestamps only have a one second resolution.
+		since: time.Now().Truncate(time.Second),
+		stopC: make(chan struct{}),
+		pods:  make(map[types.UID]*podstartup.Pod),
+	}
+
+	client := harness.KubeClient().CoreV1().RESTClient()
+
+	_, podInformer := cache.NewInformer(
+		cache.NewListWatchFromClient(client, "pods", namespace, fields.Everything()),
+		&corev1.Pod{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    tracker.onPod,
+			UpdateFunc: func(_, obj interface{}) { tracker.onPod(obj) },
+		},
+	)
+	_, eventInformer := cache.NewInformer(
+		cache.NewListWatchFromClient(client, "events", namespace, fields.OneTermEqualSelector("involvedObject.kind", "Pod")),
+		&corev1.Event{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    tracker.onEvent,
+			UpdateFunc: func(_, obj interface{}) { tracker.onEvent(obj) },
+		},
+	)
+	go podInformer.Run(tracker.stopC)
+	go eventInformer.Run(tracker.stopC)
+
+	return tracker
+}
+
+func (tracker *podStartupTracker) stop() {
+	close(tracker.stopC)
+}
+
+// get returns the startup record of a pod, creating it if needed. It must be
+// called with tracker.mu held.
+func (tracker *podStartupTracker) get(uid types.UID, name string) *podstartup.Pod {
+	p, ok := tracker.pods[uid]
+	if !ok {
+		p = &podstartup.Pod{
+			Name:  name,
+			Pulls: make(map[string]*podstartup.Pull),
+		}
+		tracker.pods[uid] = p
+	}
+	return p
+}
+
+func (tracker *podStartupTracker) onPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.CreationTimestamp.Time.Before(tracker.since) {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	p := tracker.get(pod.UID, pod.Name)
+	p.Created = pod.CreationTimestamp.Time
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case corev1.PodScheduled:
+			if p.Scheduled.IsZero() {
+				p.Scheduled = cond.LastTransitionTime.Time
+			}
+		case corev1.PodReady:
+			if p.Ready.IsZero() {
+				p.Ready = cond.LastTransitionTime.Time
+			}
+		}
+	}
+
+	if pod.Status.PodIP != "" && p.IPAssigned.IsZero() {
+		p.IPAssigned = time.Now()
+	}
+
+	if p.ContainersStarted.IsZero() && len(pod.Status.ContainerStatuses) == len(pod.Spec.Containers) {
+		var started time.Time
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Running == nil {
+				return
+			}
+			if cs.State.Running.StartedAt.Time.After(started) {
+				started = cs.State.Running.StartedAt.Time
+			}
+		}
+		p.ContainersStarted = started
+	}
+}
+
+func (tracker *podStartupTracker) onEvent(obj interface{}) {
+	ev, ok := obj.(*corev1.Event)
+	if !ok || ev.Source.Component != "kubelet" {
+		return
+	}
+
+	timestamp := ev.FirstTimestamp.Time
+	if !ev.EventTime.IsZero() {
+		timestamp = ev.EventTime.Time
+	}
+	if timestamp.Before(tracker.since) {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	p := tracker.get(ev.InvolvedObject.UID, ev.InvolvedObject.Name)
+
+	switch ev.Reason {
+	case "Pulling", "Pulled", "Created", "Started":
+		if p.SandboxCreated.IsZero() || timestamp.Before(p.SandboxCreated) {
+			p.SandboxCreated = timestamp
+		}
+	}
+
+	container := ev.InvolvedObject.FieldPath
+	switch ev.Reason {
+	case "Pulling":
+		if _, ok := p.Pulls[container]; !ok {
+			p.Pulls[container] = &podstartup.Pull{}
+		}
+		p.Pulls[container].Start = timestamp
+	case "Pulled":
+		// Also sent when the image is already present on the node, in which
+		// case no pull started.
+		if _, ok := p.Pulls[container]; !ok {
+			p.Pulls[container] = &podstartup.Pull{}
+		}
+		p.Pulls[container].End = timestamp
+	}
+}
+
+// report prints the latency distribution of each startup phase for the pods
+// that made it through that phase and adds them to report. Pods only seen
+// through their events, e.g. created before the tracker started, are left out.
+func (tracker *podStartupTracker) report(report *caseReport) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	var pods []*podstartup.Pod
+	for _, p := range tracker.pods {
+		if !p.Created.IsZero() {
+			pods = append(pods, p)
+		}
+	}
+	if len(pods) == 0 {
+		return
+	}
+
+	// phases holds the latency distribution of each phase, by name.
+	phases := make(map[string]latency.Distribution)
+	defer report.add("pod_startup", phases)
+
+	fmt.Printf("Pod startup latencies (%d pods, image pulls excluded):\n", len(pods))
+	for _, phase := range podstartup.Phases {
+		latencies := phase.Latencies(pods)
+		if len(latencies) == 0 {
+			continue
+		}
+		phases[phase.Name] = latency.Summarize(latencies)
+		fmt.Printf("%s (%d pods): %s\n", phase.Name, len(latencies), phases[phase.Name])
+	}
+}
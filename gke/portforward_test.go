@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// loadRESTConfig returns the configuration of the cluster the tests run
+// against, for the clients the harness doesn't provide.
+func loadRESTConfig() (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+}
+
+func restConfig(t *testing.T) *rest.Config {
+	config, err := loadRESTConfig()
+	if err != nil {
+		t.Fatal("error loading kubeconfig", err)
+	}
+	return config
+}
+
+// portForward forwards a random local port to port of pod until the returned
+// function is called, and returns the local port.
+func portForward(config *rest.Config, pod *corev1.Pod, port int) (int, func(), error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create port-forward transport: %w", err)
+	}
+	url := harness.KubeClient().CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stopC, readyC := make(chan struct{}), make(chan struct{})
+	forwarder, err := portforward.New(dialer, []string{fmt.Sprintf("0:%d", port)}, stopC, readyC, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to port-forward to pod %s: %w", pod.Name, err)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- forwarder.ForwardPorts()
+	}()
+	select {
+	case err := <-errC:
+		return 0, nil, fmt.Errorf("failed to port-forward to pod %s: %w", pod.Name, err)
+	case <-readyC:
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		close(stopC)
+		return 0, nil, fmt.Errorf("failed to get forwarded port of pod %s: %w", pod.Name, err)
+	}
+	return int(ports[0].Local), func() { close(stopC) }, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/versions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// agentPprofPort is the port the Cilium agent serves pprof on, on localhost,
+// when the pprof option is enabled.
+const agentPprofPort = 6060
+
+var (
+	artifactsPath      string
+	profileInterval    time.Duration
+	profileCPUDuration time.Duration
+)
+
+func init() {
+	flag.StringVar(&artifactsPath, "artifacts", "artifacts", "path of the directory the run artifacts are stored in")
+	flag.DurationVar(&profileInterval, "profile-interval", 0, "interval at which the Cilium agents are profiled during each test case, 0 to disable profiling")
+	flag.DurationVar(&profileCPUDuration, "profile-cpu-duration", 30*time.Second, "duration of each CPU profile")
+}
+
+// agentProfiles are the pprof endpoints collected from the agents, by profile
+// kind.
+func agentProfiles() map[string]string {
+	return map[string]string{
+		"cpu":       fmt.Sprintf("/debug/pprof/profile?seconds=%d", int(profileCPUDuration.Seconds())),
+		"heap":      "/debug/pprof/heap",
+		"goroutine": "/debug/pprof/goroutine",
+	}
+}
+
+// withAgentPprof enables the pprof endpoint of the Cilium agents of version.
+func withAgentPprof(version *versions.Version) objectTransform {
+	return func(obj runtime.Object) {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == "cilium-config" {
+			cm.Data[version.ConfigKeys.PProf] = "true"
+		}
+	}
+}
+
+// agentProfiler periodically collects profiles from every Cilium agent and
+// stores them in the artifacts directory, as
+// <scenario>/profiles/<node>/<kind>-<n>.pb.gz.
+type agentProfiler struct {
+	dir   string
+	stopC chan struct{}
+	wg    sync.WaitGroup
+	// closers stop the port-forwards to the agents.
+	closers []func()
+}
+
+// startAgentProfiler starts profiling the Cilium agents every profileInterval
+// for the test case scenario. It returns nil when profiling is disabled.
+func startAgentProfiler(t *testing.T, scenario string) *agentProfiler {
+	if profileInterval == 0 {
+		return nil
+	}
+
+	profiler := &agentProfiler{
+		dir:   filepath.Join(artifactsPath, scenario, "profiles"),
+		stopC: make(chan struct{}),
+	}
+	config := restConfig(t)
+	agents := harness.KubeClient().CoreV1().Pods(ciliumNamespace)
+	pods, err := agents.List(context.TODO(), metav1.ListOptions{LabelSelector: agentSelector})
+	if err != nil {
+		t.Fatal("error listing Cilium agents", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		port, closer, err := portForward(config, pod, agentPprofPort)
+		if err != nil {
+			profiler.stop()
+			t.Fatal("error reaching Cilium agent pprof endpoint", err)
+		}
+		profiler.closers = append(profiler.closers, closer)
+
+		dir := filepath.Join(profiler.dir, pod.Spec.NodeName)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			profiler.stop()
+			t.Fatal("error creating profiles directory", err)
+		}
+
+		profiler.wg.Add(1)
+		go func() {
+			defer profiler.wg.Done()
+			profiler.run(fmt.Sprintf("http://localhost:%d", port), dir)
+		}()
+	}
+
+	log.Printf("Profiling %d Cilium agents every %v to %s", len(pods.Items), profileInterval, profiler.dir)
+	return profiler
+}
+
+// run collects the profiles of one agent every profileInterval until the
+// profiler is stopped.
+func (profiler *agentProfiler) run(base, dir string) {
+	ticker := time.NewTicker(profileInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: profileCPUDuration + time.Minute}
+	for n := 0; ; n++ {
+		for kind, endpoint := range agentProfiles() {
+			file := filepath.Join(dir, fmt.Sprintf("%s-%03d.pb.gz", kind, n))
+			if err := fetchProfile(client, base+endpoint, file); err != nil {
+				log.Printf("Failed to collect %s profile: %s", kind, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-profiler.stopC:
+			return
+		}
+	}
+}
+
+// fetchProfile downloads the profile at url to file.
+func fetchProfile(client *http.Client, url, file string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+// stop waits for the profiles being collected and stops profiling.
+func (profiler *agentProfiler) stop() {
+	if profiler == nil {
+		return
+	}
+	close(profiler.stopC)
+	profiler.wg.Wait()
+	for _, closer := range profiler.closers {
+		closer()
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+var reportPath string
+
+func init() {
+	flag.StringVar(&reportPath, "report", "", "path of the JSON document the results are written to, if any")
+}
+
+// runReport is the result document of a run.
+type runReport struct {
+	mu    sync.Mutex
+	Start time.Time     `json:"start"`
+	Cases []*caseReport `json:"cases"`
+}
+
+// caseReport holds the results of a test case.
+type caseReport struct {
+	mu   sync.Mutex
+	Name string `json:"name"`
+	// Invalid lists the reasons why the measurements of the test case can't be
+	// trusted, if any.
+	Invalid []string `json:"invalid,omitempty"`
+	// Results holds the results of the test case, by kind.
+	Results map[string]interface{} `json:"results,omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		Start: time.Now(),
+	}
+}
+
+// newCase adds the results of the test case name to the report.
+func (r *runReport) newCase(name string) *caseReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := &caseReport{
+		Name:    name,
+		Results: make(map[string]interface{}),
+	}
+	r.Cases = append(r.Cases, c)
+	return c
+}
+
+// write writes the report to reportPath, if given.
+func (r *runReport) write(t *testing.T) {
+	if reportPath == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		t.Fatal("error encoding report", err)
+	}
+	if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		t.Fatal("error writing report", err)
+	}
+}
+
+// add adds results of the given kind to the test case.
+func (c *caseReport) add(kind string, results interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Results[kind] = results
+}
+
+// invalidate flags the measurements of the test case as not trustworthy.
+func (c *caseReport) invalidate(t *testing.T, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.Logf("measurements of %s are invalid: %s", c.Name, reason)
+	c.Invalid = append(c.Invalid, reason)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/saturation"
+	kt "github.com/dlespiau/kube-test-harness"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	saturationMinQPS     int
+	saturationMaxQPS     int
+	saturationStep       time.Duration
+	saturationP99        time.Duration
+	saturationErrorRatio float64
+	saturationPrecision  float64
+)
+
+func init() {
+	flag.IntVar(&saturationMinQPS, "saturation-min-qps", 100, "request rate the saturation search starts from, at least 1")
+	flag.IntVar(&saturationMaxQPS, "saturation-max-qps", 20000, "highest request rate tried by the saturation search, at least -saturation-min-qps")
+	flag.DurationVar(&saturationStep, "saturation-step", time.Minute, "duration of each load level of the saturation search")
+	flag.DurationVar(&saturationP99, "saturation-p99", 100*time.Millisecond, "p99 latency a load level must stay under to be sustainable")
+	flag.Float64Var(&saturationErrorRatio, "saturation-errors", 0.01, "share of failed requests a load level must stay under to be sustainable")
+	flag.Float64Var(&saturationPrecision, "saturation-precision", 0.05, "the saturation search stops when the unsustainable rate is within this positive fraction of the sustainable one")
+}
+
+// saturationLevel is the measurement of one load level of the saturation search.
+type saturationLevel struct {
+	QPS        int           `json:"qps"`
+	ActualQPS  float64       `json:"actual_qps"`
+	P99        time.Duration `json:"p99"`
+	ErrorRatio float64       `json:"error_ratio"`
+	// AgentCPU is the CPU used by the Cilium agents on top of their idle
+	// usage, summed across nodes, in cores.
+	AgentCPU float64 `json:"agent_cpu"`
+	// CPUPerKQPS is the agent CPU used per 1000 requests per second, in
+	// cores.
+	CPUPerKQPS  float64 `json:"cpu_per_kqps"`
+	Sustainable bool    `json:"sustainable"`
+}
+
+func (l saturationLevel) String() string {
+	return fmt.Sprintf("%d qps: %.1f qps achieved, p99=%v, %.2f%% errors, agent CPU %.3f cores (%.3f cores per 1k qps)",
+		l.QPS, l.ActualQPS, l.P99, l.ErrorRatio*100, l.AgentCPU, l.CPUPerKQPS)
+}
+
+// saturationResult is the outcome of the saturation search.
+type saturationResult struct {
+	// IdleAgentCPU is the CPU used by the Cilium agents before any load is
+	// sent, in cores.
+	IdleAgentCPU float64           `json:"idle_agent_cpu"`
+	Levels       []saturationLevel `json:"levels"`
+	// Saturation is the highest sustainable load level, nil if even the
+	// lowest load level wasn't sustainable.
+	Saturation *saturationLevel `json:"saturation"`
+}
+
+// withoutABChainWaits makes abchain answer right away instead of holding each
+// request for ABC_MIN_WAIT to ABC_MAX_WAIT, which would cap the rate a few
+// connections can send far below what the datapath sustains.
+func withoutABChainWaits(obj runtime.Object) {
+	d, ok := obj.(*appsv1.Deployment)
+	if !ok || d.Name != "abchain" {
+		return
+	}
+	for i := range d.Spec.Template.Spec.Containers {
+		env := d.Spec.Template.Spec.Containers[i].Env
+		for j := range env {
+			switch env[j].Name {
+			case "ABC_MIN_WAIT":
+				env[j].Value = "0s"
+			case "ABC_MAX_WAIT":
+				env[j].Value = "1ms"
+			}
+		}
+	}
+}
+
+// saturationConnections returns the number of connections fortio needs to send
+// qps requests per second with responses taking up to the p99 target, and at
+// least -load-connections.
+func saturationConnections(qps int) int {
+	connections := int(math.Ceil(float64(qps) * saturationP99.Seconds()))
+	if connections < loadConnections {
+		connections = loadConnections
+	}
+	return connections
+}
+
+// runSaturation sends an increasing request rate to the abchain workload with
+// the in-cluster load generator. The rate doubles until the latency or error
+// SLO is missed, then a binary search narrows down the highest sustainable rate.
+// The agent CPU is measured at every load level to get the cost per unit of
+// traffic.
+func runSaturation(t *testing.T, test *kt.Test, report *caseReport) {
+	if err := saturation.Check(saturationMinQPS, saturationMaxQPS, saturationPrecision); err != nil {
+		t.Fatal("invalid saturation search flags:", err)
+	}
+	promv1api := newPrometheusAPI(t, prometheusURL(t, test))
+
+	log.Printf("Measuring idle agent usage for %v...", saturationStep)
+	<-time.After(saturationStep)
+	result := &saturationResult{
+		IdleAgentCPU: measureAgentUsage(t, promv1api, saturationStep).agentCPU,
+	}
+	defer report.add("saturation", result)
+
+	measure := func(qps int) bool {
+		log.Printf("Sending %d qps for %v...", qps, saturationStep)
+		load := runLoad(t, test, test.Namespace, "http://port-abc:3770/", qps, saturationConnections(qps), saturationStep)
+		usage := measureAgentUsage(t, promv1api, saturationStep)
+
+		level := saturationLevel{
+			QPS:       qps,
+			ActualQPS: load.ActualQPS,
+			P99:       load.percentile(99),
+			AgentCPU:  usage.agentCPU - result.IdleAgentCPU,
+		}
+		if level.ActualQPS > 0 {
+			level.CPUPerKQPS = level.AgentCPU / level.ActualQPS * 1000
+		}
+		if load.DurationHistogram.Count > 0 {
+			level.ErrorRatio = float64(load.errors()) / float64(load.DurationHistogram.Count)
+		}
+		// fortio sends fewer requests than asked for when the responses are
+		// too slow to keep up.
+		level.Sustainable = level.P99 <= saturationP99 &&
+			level.ErrorRatio <= saturationErrorRatio &&
+			level.ActualQPS >= 0.95*float64(qps)
+
+		log.Printf("%s, sustainable: %t", level, level.Sustainable)
+		result.Levels = append(result.Levels, level)
+		if level.Sustainable && (result.Saturation == nil || qps > result.Saturation.QPS) {
+			result.Saturation = &level
+		}
+		return level.Sustainable
+	}
+
+	_, high, err := saturation.Search(saturationMinQPS, saturationMaxQPS, saturationPrecision, measure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("Saturation search (p99 <= %v, errors <= %.2f%%), idle agent CPU %.3f cores:\n",
+		saturationP99, saturationErrorRatio*100, result.IdleAgentCPU)
+	for _, level := range result.Levels {
+		fmt.Printf("  %s, sustainable: %t\n", level, level.Sustainable)
+	}
+	switch {
+	case result.Saturation == nil:
+		fmt.Printf("Saturation point: below %d qps\n", saturationMinQPS)
+	case high == 0:
+		fmt.Printf("Saturation point: above %s\n", *result.Saturation)
+	default:
+		fmt.Printf("Saturation point: %s\n", *result.Saturation)
+	}
+}
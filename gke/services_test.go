@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	kt "github.com/dlespiau/kube-test-harness"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const serviceBackendDeployment = "service-backend"
+
+var (
+	clusterIPServices int
+	nodePortServices  int
+	serviceBackends   int
+)
+
+func init() {
+	flag.IntVar(&clusterIPServices, "clusterip-services", 2000, "number of ClusterIP services created by the service scale test")
+	flag.IntVar(&nodePortServices, "nodeport-services", 500, "number of NodePort services created by the service scale test")
+	flag.IntVar(&serviceBackends, "service-backends", 10, "number of backends of each service created by the service scale test")
+}
+
+// watchLoad is the apiserver watch load of a kind of object.
+type watchLoad struct {
+	Watchers float64 `json:"watchers"`
+	// Events is the number of watch events sent during the test case.
+	Events float64 `json:"events"`
+}
+
+// serviceScaleReport holds the results of the service scale test.
+type serviceScaleReport struct {
+	Services int `json:"services"`
+	Backends int `json:"backends"`
+	// Created is the time it took to create the services and their
+	// endpoints.
+	Created time.Duration `json:"created"`
+	// Programmed is the time from the start of the creation until every
+	// agent programmed all the services.
+	Programmed time.Duration `json:"programmed"`
+	// Watches holds the apiserver watch load by kind, if it could be
+	// measured.
+	Watches map[string]watchLoad `json:"watches,omitempty"`
+}
+
+// runServiceScale creates clusterIPServices ClusterIP and nodePortServices
+// NodePort services with serviceBackends backends each and measures how long
+// it takes for every Cilium agent to program them. The services don't have a
+// selector, their Endpoints are created by the test and point to the pods of
+// the service-backend deployment.
+func runServiceScale(t *testing.T, test *kt.Test, report *caseReport) {
+	start := time.Now()
+
+	workloads := deployManifest(t, test, path.Join(sharedManifestPath, "service-backends.yaml"), test.Namespace)
+	scaleDeployment(t, test.Namespace, serviceBackendDeployment, serviceBackends)
+	waitForWorkloads(t, workloads, 5*time.Minute)
+
+	var addresses []corev1.EndpointAddress
+	for _, p := range test.ListPods(test.Namespace, metav1.ListOptions{LabelSelector: "app=" + serviceBackendDeployment}).Items {
+		nodeName := p.Spec.NodeName
+		addresses = append(addresses, corev1.EndpointAddress{
+			IP:       p.Status.PodIP,
+			NodeName: &nodeName,
+		})
+	}
+
+	promv1api := newPrometheusAPI(t, prometheusURL(t, test))
+	// Each agent counts the services it has added to its load-balancing maps.
+	programmedBefore := queryMetric(t, promv1api, "min", metrics.ServicesAdded, time.Minute)
+
+	apiserverBefore, err := scrapeAPIServerMetrics()
+	if err != nil {
+		t.Log("not measuring apiserver load:", err)
+	}
+
+	total := clusterIPServices + nodePortServices
+	log.Printf("Creating %d ClusterIP and %d NodePort services with %d backends each...",
+		clusterIPServices, nodePortServices, len(addresses))
+	createStart := time.Now()
+	createServices(t, test.Namespace, addresses)
+	created := time.Since(createStart)
+
+	if err := wait.Poll(5*time.Second, 15*time.Minute, func() (bool, error) {
+		programmed := queryMetric(t, promv1api, "min", metrics.ServicesAdded, time.Minute) - programmedBefore
+		return programmed >= float64(total), nil
+	}); err != nil {
+		t.Fatalf("services not programmed by every agent: %s", err)
+	}
+	result := &serviceScaleReport{
+		Services:   total,
+		Backends:   len(addresses),
+		Created:    created,
+		Programmed: time.Since(createStart),
+	}
+	defer report.add("services", result)
+
+	fmt.Printf("Services: created %d services in %v, programmed by all agents in %v\n", total, result.Created, result.Programmed)
+
+	if remaining := duration - time.Since(start); remaining > 0 {
+		log.Printf("Letting the cluster run for %v to gather metrics...", remaining)
+		<-time.After(remaining)
+	}
+
+	if apiserverBefore != nil {
+		apiserverAfter, err := scrapeAPIServerMetrics()
+		if err != nil {
+			t.Fatal("error measuring apiserver load", err)
+		}
+		result.Watches = make(map[string]watchLoad)
+		for _, kind := range []string{"Service", "Endpoints"} {
+			labels := map[string]string{"kind": kind}
+			w := watchLoad{
+				Watchers: apiserverAfter.sum("apiserver_registered_watchers", labels),
+				Events:   apiserverAfter.sum("apiserver_watch_events_total", labels) - apiserverBefore.sum("apiserver_watch_events_total", labels),
+			}
+			result.Watches[kind] = w
+			fmt.Printf("apiserver %s: %v watchers, %v watch events sent\n", kind, w.Watchers, w.Events)
+		}
+	}
+}
+
+// createServices creates the services of the service scale test and their
+// endpoints, pointing to addresses.
+func createServices(t *testing.T, namespace string, addresses []corev1.EndpointAddress) {
+	client := newBulkClient(t)
+
+	var eg errgroup.Group
+	workers := make(chan struct{}, 16)
+	for i := 0; i < clusterIPServices+nodePortServices; i++ {
+		serviceType := corev1.ServiceTypeClusterIP
+		if i >= clusterIPServices {
+			serviceType = corev1.ServiceTypeNodePort
+		}
+		name := fmt.Sprintf("svc-%d", i)
+
+		workers <- struct{}{}
+		eg.Go(func() error {
+			defer func() { <-workers }()
+
+			endpoints := &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Subsets: []corev1.EndpointSubset{{
+					Addresses: addresses,
+					Ports: []corev1.EndpointPort{{
+						Name:     "http",
+						Port:     8080,
+						Protocol: corev1.ProtocolTCP,
+					}},
+				}},
+			}
+			if _, err := client.CoreV1().Endpoints(namespace).Create(context.TODO(), endpoints, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("failed to create endpoints %s: %w", name, err)
+			}
+			if err := record(corev1.SchemeGroupVersion.WithKind("Endpoints"), namespace, name); err != nil {
+				return err
+			}
+
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec: corev1.ServiceSpec{
+					Type: serviceType,
+					Ports: []corev1.ServicePort{{
+						Name:       "http",
+						Port:       80,
+						TargetPort: intstr.FromInt(8080),
+						Protocol:   corev1.ProtocolTCP,
+					}},
+				},
+			}
+			if _, err := client.CoreV1().Services(namespace).Create(context.TODO(), service, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("failed to create service %s: %w", name, err)
+			}
+			return record(corev1.SchemeGroupVersion.WithKind("Service"), namespace, name)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newBulkClient returns a Kubernetes client with a higher rate limit than the
+// harness client, for tests creating thousands of objects.
+func newBulkClient(t *testing.T) kubernetes.Interface {
+	config := restConfig(t)
+	config.QPS = 100
+	config.Burst = 200
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal("error creating kubernetes client", err)
+	}
+	return client
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	"github.com/cilium/cilium-perf-test/internal/trend"
+	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	soakDuration       time.Duration
+	soakWarmup         time.Duration
+	soakChurnInterval  time.Duration
+	soakSampleInterval time.Duration
+	leakMaxPValue      float64
+	leakMinGrowth      float64
+)
+
+func init() {
+	flag.DurationVar(&soakDuration, "soak", 0, "duration of the soak run, 0 to run the test cases instead")
+	flag.DurationVar(&soakWarmup, "soak-warmup", 30*time.Minute, "time at the start of the soak run left out of the leak trends, while the agent caches fill up")
+	flag.DurationVar(&soakChurnInterval, "soak-churn-interval", 5*time.Minute, "time between two scale operations of the churn deployment during the soak run")
+	flag.DurationVar(&soakSampleInterval, "soak-sample-interval", time.Minute, "interval at which the leak metrics are sampled during the soak run")
+	flag.Float64Var(&leakMaxPValue, "leak-max-pvalue", 0.01, "p-value below which the growth of a metric during the soak run is significant")
+	flag.Float64Var(&leakMinGrowth, "leak-min-growth", 0.01, "growth per hour, relative to the mean, above which a significant growth is reported as a leak")
+}
+
+// soakManifests are the workload mix of the soak run.
+var soakManifests = []string{"abchain.yaml", "churn.yaml"}
+
+// leakMetrics are the agent metrics whose trend is fitted during soak runs.
+var leakMetrics = []metrics.Name{
+	metrics.AgentResidentMemory,
+	metrics.AgentGoHeap,
+	metrics.BPFMapsMemory,
+	metrics.AgentGoroutines,
+	metrics.AgentOpenFDs,
+}
+
+// leakResult is the trend of a metric of an agent pod during a soak run.
+type leakResult struct {
+	Metric string    `json:"metric"`
+	Pod    string    `json:"pod"`
+	Fit    trend.Fit `json:"fit"`
+	Leak   bool      `json:"leak"`
+}
+
+// TestSoak runs the soak workload mix for soakDuration, churning endpoints
+// every soakChurnInterval, then fits a trend to the leak metrics of every
+// agent past soakWarmup and fails if any of them grows significantly.
+func TestSoak(t *testing.T) {
+	if soakDuration == 0 {
+		t.Skip("soak mode disabled, set -soak to enable it")
+	}
+	if soakWarmup >= soakDuration {
+		t.Fatalf("-soak-warmup %v leaves nothing of the %v soak run to fit", soakWarmup, soakDuration)
+	}
+	setupCluster(t)
+
+	report := newRunReport()
+	defer report.write(t)
+
+	test := harness.NewTest(t)
+	test.Setup()
+	recordNamespace(t, test.Namespace)
+	defer test.Close()
+	defer collectDiagnostics(t, test.Namespace, "soak")
+
+	caseReport := report.newCase("soak")
+
+	var workloads []readiness.Object
+	for _, manifest := range soakManifests {
+		workloads = append(workloads, deployManifest(t, test, path.Join(sharedManifestPath, manifest), test.Namespace)...)
+	}
+	waitForWorkloads(t, workloads, 5*time.Minute)
+
+	profiler := startAgentProfiler(t, "soak")
+	defer profiler.stop()
+
+	log.Printf("Soaking for %v, churning deployment %s between %d and %d replicas every %v...",
+		soakDuration, churnDeployment, churnMin, churnMax, soakChurnInterval)
+	// The metrics grow while the agents warm up, e.g. fill their caches,
+	// which would be mistaken for a leak.
+	start := time.Now().Add(soakWarmup)
+	churn(t, test, soakChurnInterval, soakDuration)
+
+	queryLeaks(t, prometheusURL(t, test), start, time.Now(), caseReport)
+}
+
+// queryLeaks fits a trend to the samples of the leak metrics of every agent
+// between start and end, adds them to report and fails the test for the ones
+// growing significantly.
+func queryLeaks(t *testing.T, base string, start, end time.Time, report *caseReport) {
+	promv1api := newPrometheusAPI(t, base)
+	r := prometheusv1.Range{
+		Start: start,
+		End:   end,
+		Step:  soakSampleInterval,
+	}
+
+	m := monitoring()
+	set := ciliumMetrics()
+	var results []leakResult
+	for _, name := range leakMetrics {
+		q, err := set.ByPod(name, m, soakSampleInterval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each query spans the whole soak run, give it its own
+		// timeout.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result, _, err := promv1api.QueryRange(ctx, q, r)
+		cancel()
+		if err != nil {
+			t.Fatal("error querying Prometheus", err)
+		}
+		matrix, ok := result.(model.Matrix)
+		if !ok {
+			t.Fatalf("unexpected result type %s for query %q", result.Type(), q)
+		}
+
+		for _, stream := range matrix {
+			samples := make([]trend.Sample, 0, len(stream.Values))
+			for _, v := range stream.Values {
+				samples = append(samples, trend.Sample{Time: v.Timestamp.Time(), Value: float64(v.Value)})
+			}
+			pod := string(stream.Metric[model.LabelName(m.TargetPodLabel)])
+			fit, err := trend.LeastSquares(samples)
+			if err != nil {
+				// The series of an agent pod created late in the
+				// soak run, e.g. after a restart, may be too short.
+				t.Logf("not fitting %s of %s: %s", name, pod, err)
+				continue
+			}
+			results = append(results, leakResult{
+				Metric: string(name),
+				Pod:    pod,
+				Fit:    fit,
+				Leak:   fit.Growing(leakMaxPValue, leakMinGrowth),
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Metric != results[j].Metric {
+			return results[i].Metric < results[j].Metric
+		}
+		return results[i].Pod < results[j].Pod
+	})
+	report.add("leaks", results)
+
+	fmt.Printf("Soak trends:\n")
+	for _, r := range results {
+		fmt.Printf("%s %s: %+.4g/h (%+.2f%%/h of %.4g, p=%.2g)\n",
+			r.Metric, r.Pod, r.Fit.SlopePerHour, 100*r.Fit.RelativeSlopePerHour(), r.Fit.Mean, r.Fit.PValue)
+		if r.Leak {
+			t.Errorf("%s of %s grows by %.2f%% per hour", r.Metric, r.Pod, 100*r.Fit.RelativeSlopePerHour())
+		}
+	}
+}
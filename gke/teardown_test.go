@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+
+	"github.com/cilium/cilium-perf-test/internal/ledger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	ledgerPath string
+
+	// objects records the objects created by the run, so that the ones the
+	// harness doesn't clean up, e.g. because a test failed or the run was
+	// interrupted, are deleted at the end of the run.
+	objects       *ledger.Ledger
+	deleteObjects func(ledger.Entry) error
+)
+
+func init() {
+	flag.StringVar(&ledgerPath, "ledger", "perf-test.ledger", "path of the file the objects created by the run are recorded in, for the cleanup command to delete them if the run is killed")
+}
+
+// openLedger opens the ledger, deletes the objects left behind by a previous
+// run and makes sure the objects of this run are deleted if it is interrupted.
+func openLedger() error {
+	config, err := loadRESTConfig()
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	deleteObjects = ledger.Deleter(client)
+
+	if objects, err = ledger.Open(ledgerPath); err != nil {
+		return err
+	}
+	if n := len(objects.Entries()); n > 0 {
+		log.Printf("Deleting %d objects left behind by a previous run...", n)
+		if err := objects.Teardown(deleteObjects); err != nil {
+			return err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, deleting the objects created by the run...", sig)
+		teardownObjects()
+		os.Exit(1)
+	}()
+	return nil
+}
+
+// teardownObjects deletes the objects created by the run, the last created
+// first.
+func teardownObjects() {
+	if err := objects.Teardown(deleteObjects); err != nil {
+		log.Printf("Failed to delete every object created by the run, run the cleanup command with -ledger %s: %s", ledgerPath, err)
+	}
+}
+
+// recordObject records the object name of kind gvk created by the run in
+// namespace, which is empty for cluster-scoped objects.
+func recordObject(t *testing.T, gvk schema.GroupVersionKind, namespace, name string) {
+	if err := record(gvk, namespace, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// record is recordObject for goroutines, which can't fail the test.
+func record(gvk schema.GroupVersionKind, namespace, name string) error {
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	return objects.Record(ledger.Entry{
+		Group:     gvr.Group,
+		Version:   gvr.Version,
+		Resource:  gvr.Resource,
+		Namespace: namespace,
+		Name:      name,
+	})
+}
+
+// recordNamespace records the namespace name created by the run.
+func recordNamespace(t *testing.T, name string) {
+	recordObject(t, corev1.SchemeGroupVersion.WithKind("Namespace"), "", name)
+}
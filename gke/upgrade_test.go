@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	"github.com/cilium/cilium-perf-test/internal/versions"
+	kt "github.com/dlespiau/kube-test-harness"
+	"github.com/prometheus/common/model"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var (
+	upgradeFrom          string
+	upgradeTo            string
+	upgradeToVersionName string
+
+	// upgradeToVersion is the registry entry of the Cilium version of the
+	// upgradeTo manifest.
+	upgradeToVersion *versions.Version
+)
+
+func init() {
+	flag.StringVar(&upgradeFrom, "upgrade-from", "", "rendered Cilium manifest the cilium-upgrade test case starts from, the gke manifest of -cilium-version by default")
+	flag.StringVar(&upgradeTo, "upgrade-to", "", "rendered Cilium manifest the cilium-upgrade test case upgrades to, the test case is skipped when empty")
+	flag.StringVar(&upgradeToVersionName, "upgrade-to-version", "", fmt.Sprintf("Cilium version of the -upgrade-to manifest, one of %v, -cilium-version by default", versions.Names()))
+}
+
+// transitionMetrics are the metrics whose peak is reported for each transition
+// of the cilium-upgrade test case.
+var transitionMetrics = []metrics.Name{
+	metrics.AgentCPU,
+	metrics.AgentResidentMemory,
+	metrics.OperatorCPU,
+	metrics.OperatorResidentMemory,
+	metrics.EndpointRegenerations,
+	metrics.EndpointRegenerationP99,
+}
+
+// transitionReport holds the results of a rolling update of Cilium from one
+// manifest to another.
+type transitionReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// AgentsReady is the time from the start of the update until the agents
+	// of all nodes were replaced and ready.
+	AgentsReady time.Duration `json:"agents_ready"`
+	// WorkloadsReady is the time from the start of the update until all the
+	// Cilium workloads, e.g. the operator, were rolled out.
+	WorkloadsReady time.Duration `json:"workloads_ready"`
+	// EndpointsRegenerated is the time from the start of the update until
+	// all the endpoints of the new agents were ready.
+	EndpointsRegenerated time.Duration `json:"endpoints_regenerated"`
+	// Nodes holds the rollout of each node.
+	Nodes map[string]agentRecovery `json:"nodes"`
+	// BaselineP99 is the p99 latency of the traffic before the update.
+	BaselineP99 time.Duration `json:"baseline_p99"`
+	loadImpact
+	// Peaks holds the highest value of each metric, summed across pods,
+	// during the update.
+	Peaks map[string]float64 `json:"peaks"`
+}
+
+// runUpgrade rolls Cilium from the upgradeFrom manifest to the upgradeTo one
+// and back while traffic flows through the abchain workload, and reports the
+// cost of both transitions.
+func runUpgrade(t *testing.T, test *kt.Test, report *caseReport) {
+	from := upgradeFrom
+	if from == "" {
+		var err error
+		if from, err = ciliumVersion.CiliumManifest(manifestPath, "gke"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if upgradeFrom != "" {
+		// The DaemonSet is only ready once all its pods run the updated
+		// template, if it changed at all.
+		log.Printf("Rolling Cilium out to %s before the upgrade...", from)
+		waitForWorkloads(t, rolloutCilium(t, from, ciliumVersion), 15*time.Minute)
+	}
+
+	var transitions []transitionReport
+	for _, to := range []struct {
+		manifest string
+		version  *versions.Version
+	}{
+		{upgradeTo, upgradeToVersion},
+		{from, ciliumVersion},
+	} {
+		transitions = append(transitions, runTransition(t, test, from, to.manifest, to.version))
+		from = to.manifest
+	}
+	report.add("upgrade", transitions)
+
+	for _, r := range transitions {
+		fmt.Printf("%s -> %s: agents ready after %v, workloads after %v, endpoints regenerated after %v\n",
+			filepath.Base(r.From), filepath.Base(r.To), r.AgentsReady, r.WorkloadsReady, r.EndpointsRegenerated)
+		var nodes []string
+		for node := range r.Nodes {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			n := r.Nodes[node]
+			fmt.Printf("  %s: %s ready after %v (%v after its creation)\n", node, n.Pod, n.Ready, n.Startup)
+		}
+		fmt.Printf("  traffic: %d/%d failed requests, %d reconnections, p99 %v (%v before), max %v\n",
+			r.FailedRequests, r.Requests, r.Reconnections, r.P99, r.BaselineP99, r.Max)
+		fmt.Printf("  peaks: %v\n", r.Peaks)
+	}
+}
+
+// runTransition measures the baseline latency of the traffic for
+// disruptionWarmup, then rolls Cilium out from the manifest from to the
+// manifest to of version while traffic flows for the test duration.
+func runTransition(t *testing.T, test *kt.Test, from, to string, version *versions.Version) transitionReport {
+	result := transitionReport{From: from, To: to}
+
+	log.Printf("Measuring the baseline latency for %v...", disruptionWarmup)
+	baseline := runLoad(t, test, test.Namespace, "http://port-abc:3770/", loadQPS, loadConnections, disruptionWarmup)
+	result.BaselineP99 = baseline.percentile(99)
+
+	job := startLoad(t, test.Namespace, "http://port-abc:3770/", loadQPS, loadConnections, duration)
+	old := agentPods(t)
+	start := time.Now()
+	log.Printf("Rolling Cilium out from %s to %s...", from, to)
+	workloads := rolloutCilium(t, to, version)
+
+	var pods []corev1.Pod
+	result.Nodes, pods = waitForNewAgents(t, old, start, 15*time.Minute)
+	result.AgentsReady = time.Since(start)
+	waitForWorkloads(t, workloads, 10*time.Minute)
+	result.WorkloadsReady = time.Since(start)
+	waitForEndpointsReady(t, pods, 5*time.Minute)
+	result.EndpointsRegenerated = time.Since(start)
+
+	load := waitLoad(t, test, test.Namespace, job, duration)
+	result.loadImpact = newLoadImpact(load)
+	result.Peaks = queryPeaks(t, prometheusURL(t, test), start, time.Now(), transitionMetrics)
+	return result
+}
+
+// rolloutCilium updates the Cilium ConfigMap, ClusterRoles, DaemonSets and
+// Deployments in ciliumNamespace to the ones of the rendered manifest of
+// version, which rolls the agents out one node at a time following the update
+// strategy of the DaemonSet. The other objects are kept from the current
+// version. It returns the workloads of the manifest.
+func rolloutCilium(t *testing.T, manifest string, version *versions.Version) []readiness.Object {
+	client := harness.KubeClient()
+	ctx := context.TODO()
+
+	var configs, workloads []runtime.Object
+	for _, d := range loadYAML(t, manifest) {
+		if len(d) < 2 {
+			continue
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(d, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %s", manifest, err)
+		}
+		for _, transform := range ciliumTransforms(version) {
+			transform(obj)
+		}
+		withImageMapping(obj)
+		switch obj.(type) {
+		case *corev1.ConfigMap, *rbacv1.ClusterRole:
+			configs = append(configs, obj)
+		case *appsv1.DaemonSet, *appsv1.Deployment:
+			workloads = append(workloads, obj)
+		}
+	}
+
+	// Update the configuration first so that the new pods start with it.
+	var objects []readiness.Object
+	for _, obj := range append(configs, workloads...) {
+		var err error
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			var cur *corev1.ConfigMap
+			if cur, err = client.CoreV1().ConfigMaps(ciliumNamespace).Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+				cur.Data = o.Data
+				_, err = client.CoreV1().ConfigMaps(ciliumNamespace).Update(ctx, cur, metav1.UpdateOptions{})
+			}
+		case *rbacv1.ClusterRole:
+			var cur *rbacv1.ClusterRole
+			if cur, err = client.RbacV1().ClusterRoles().Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+				cur.Rules = o.Rules
+				_, err = client.RbacV1().ClusterRoles().Update(ctx, cur, metav1.UpdateOptions{})
+			}
+		case *appsv1.DaemonSet:
+			var cur *appsv1.DaemonSet
+			if cur, err = client.AppsV1().DaemonSets(ciliumNamespace).Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+				cur.Spec.Template = o.Spec.Template
+				cur.Spec.UpdateStrategy = o.Spec.UpdateStrategy
+				_, err = client.AppsV1().DaemonSets(ciliumNamespace).Update(ctx, cur, metav1.UpdateOptions{})
+			}
+		case *appsv1.Deployment:
+			var cur *appsv1.Deployment
+			if cur, err = client.AppsV1().Deployments(ciliumNamespace).Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+				cur.Spec.Template = o.Spec.Template
+				cur.Spec.Strategy = o.Spec.Strategy
+				cur.Spec.Replicas = o.Spec.Replicas
+				_, err = client.AppsV1().Deployments(ciliumNamespace).Update(ctx, cur, metav1.UpdateOptions{})
+			}
+		}
+		if apierrors.IsNotFound(err) {
+			t.Fatalf("%T missing from the current Cilium deployment, rolling out a manifest with new components isn't supported: %s", obj, err)
+		}
+		if err != nil {
+			t.Fatalf("failed to update %T: %s", obj, err)
+		}
+		if workload, ok := readiness.Workload(obj, ciliumNamespace); ok {
+			objects = append(objects, workload)
+		}
+	}
+	return objects
+}
+
+// queryPeaks returns the highest value of the metrics names, summed across
+// pods, between start and end, by series. The series of both the versions the
+// run rolls out to are matched, as the agents of both run during a transition.
+func queryPeaks(t *testing.T, base string, start, end time.Time, names []metrics.Name) map[string]float64 {
+	promv1api := newPrometheusAPI(t, base)
+
+	set := ciliumMetrics()
+	peaks := make(map[string]float64)
+	for _, name := range names {
+		q, err := set.Aggregate("sum", name, monitoring(), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q = fmt.Sprintf("max_over_time((%s)[%ds:15s])", q, int(end.Sub(start).Seconds()))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		result, _, err := promv1api.Query(ctx, q, end)
+		cancel()
+		if err != nil {
+			t.Fatal("error querying Prometheus", err)
+		}
+		vector, ok := result.(model.Vector)
+		if !ok {
+			t.Fatalf("unexpected result type %s for query %q", result.Type(), q)
+		}
+		by := set[name].By
+		for _, sample := range vector {
+			peaks[seriesName(name, by, string(sample.Metric[model.LabelName(by)]))] = float64(sample.Value)
+		}
+	}
+	return peaks
+}
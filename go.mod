@@ -4,8 +4,15 @@ go 1.14
 
 require (
 	github.com/dlespiau/kube-test-harness v0.0.0-20200821153828-01d33ff97e98
+	github.com/google/pprof v0.0.0-20200708004538-1a94d8640e99
+	github.com/googleapis/gnostic v0.1.0
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.10.0
+	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 	k8s.io/api v0.18.8
 	k8s.io/apimachinery v0.18.8
 	k8s.io/client-go v0.18.8
+	k8s.io/kube-openapi v0.0.0-20200410145947-61e04a5be9a6
+	sigs.k8s.io/yaml v1.2.0
 )
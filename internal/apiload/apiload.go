@@ -0,0 +1,259 @@
+// Package apiload computes the load a client, e.g. Cilium, put on
+// kube-apiserver between two scrapes of the apiserver metrics.
+//
+// Older apiservers label apiserver_request_total with the user agent of the
+// client, newer ones don't. When a series has no client label, the requests
+// are attributed from their API group instead, which only accounts for the
+// requests to the client custom resources. With API priority and fairness
+// enabled, a FlowSchema matching the client service accounts gives the rate of
+// all of its requests, without a breakdown by verb and resource.
+package apiload
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Client identifies the requests of a client.
+type Client struct {
+	// UserAgent is the prefix of the user agent of the client, e.g.
+	// "cilium".
+	UserAgent string
+	// Group is the API group of the client custom resources, e.g.
+	// "cilium.io".
+	Group string
+	// FlowSchema is the name of a FlowSchema matching the requests of the
+	// client only, if any.
+	FlowSchema string
+}
+
+// Load is the load a client put on the apiserver over a time window.
+type Load struct {
+	Window time.Duration `json:"window"`
+	// Filters lists how the requests were attributed to the client, "client"
+	// for the user agent, "group" for the API group and "flow_schema" for
+	// the FlowSchema.
+	Filters []string `json:"filters"`
+	// Partial is set when the requests were attributed by API group, in
+	// which case Requests, Errors and LatencyP99 only account for the
+	// requests to the client custom resources.
+	Partial bool `json:"partial,omitempty"`
+	// TotalRequests is the rate of all the requests of the client per
+	// second, dispatched through its FlowSchema.
+	TotalRequests float64 `json:"total_requests_per_second,omitempty"`
+	// Requests is the rate of requests per second, by verb and resource,
+	// e.g. "LIST ciliumidentities".
+	Requests map[string]float64 `json:"requests_per_second"`
+	// Errors is the rate of requests per second that failed with a 5xx
+	// code, by verb and resource.
+	Errors map[string]float64 `json:"errors_per_second,omitempty"`
+	// LatencyP99 is the 99th percentile of the request latency, by verb.
+	LatencyP99 map[string]float64 `json:"latency_p99_seconds"`
+}
+
+func labelValue(m *dto.Metric, name string) (string, bool) {
+	for _, pair := range m.Label {
+		if pair.GetName() == name {
+			return pair.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+// match tells whether the series m was requested by c, and how.
+func (c Client) match(m *dto.Metric) (bool, string) {
+	if client, ok := labelValue(m, "client"); ok {
+		return strings.HasPrefix(strings.ToLower(client), strings.ToLower(c.UserAgent)), "client"
+	}
+	group, _ := labelValue(m, "group")
+	return group == c.Group, "group"
+}
+
+// requestKey returns the verb and resource of the series m.
+func requestKey(m *dto.Metric) string {
+	verb, _ := labelValue(m, "verb")
+	resource, _ := labelValue(m, "resource")
+	if sub, _ := labelValue(m, "subresource"); sub != "" {
+		resource += "/" + sub
+	}
+	return strings.TrimSpace(verb + " " + resource)
+}
+
+// seriesKey identifies a series by its labels.
+func seriesKey(m *dto.Metric) string {
+	var pairs []string
+	for _, pair := range m.Label {
+		pairs = append(pairs, pair.GetName()+"="+pair.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func series(families map[string]*dto.MetricFamily, name string) map[string]*dto.Metric {
+	s := make(map[string]*dto.Metric)
+	if family, ok := families[name]; ok {
+		for _, m := range family.Metric {
+			s[seriesKey(m)] = m
+		}
+	}
+	return s
+}
+
+func counterValue(m *dto.Metric) float64 {
+	switch {
+	case m == nil:
+		return 0
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
+
+// Diff returns the load client put on the apiserver between the metrics
+// before and after, scraped window apart.
+func Diff(before, after map[string]*dto.MetricFamily, window time.Duration, client Client) Load {
+	load := Load{
+		Window:     window,
+		Requests:   make(map[string]float64),
+		Errors:     make(map[string]float64),
+		LatencyP99: make(map[string]float64),
+	}
+	filters := make(map[string]bool)
+	seconds := window.Seconds()
+
+	prev := series(before, "apiserver_request_total")
+	for key, m := range series(after, "apiserver_request_total") {
+		ok, filter := client.match(m)
+		if !ok {
+			continue
+		}
+		filters[filter] = true
+		delta := counterValue(m) - counterValue(prev[key])
+		if delta <= 0 || seconds <= 0 {
+			continue
+		}
+		load.Requests[requestKey(m)] += delta / seconds
+		if code, _ := labelValue(m, "code"); strings.HasPrefix(code, "5") {
+			load.Errors[requestKey(m)] += delta / seconds
+		}
+	}
+
+	// The latency histograms of the requests of the client, merged by verb.
+	byVerb := make(map[string]map[float64]float64)
+	prev = series(before, "apiserver_request_duration_seconds")
+	for key, m := range series(after, "apiserver_request_duration_seconds") {
+		if m.Histogram == nil {
+			continue
+		}
+		ok, filter := client.match(m)
+		if !ok {
+			continue
+		}
+		filters[filter] = true
+		verb, _ := labelValue(m, "verb")
+		if byVerb[verb] == nil {
+			byVerb[verb] = make(map[float64]float64)
+		}
+		previous := make(map[float64]uint64)
+		if p := prev[key]; p != nil && p.Histogram != nil {
+			for _, b := range p.Histogram.Bucket {
+				previous[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+		for _, b := range m.Histogram.Bucket {
+			if count := b.GetCumulativeCount(); count >= previous[b.GetUpperBound()] {
+				byVerb[verb][b.GetUpperBound()] += float64(count - previous[b.GetUpperBound()])
+			}
+		}
+	}
+	for verb, buckets := range byVerb {
+		if q := quantile(0.99, buckets); !math.IsNaN(q) {
+			load.LatencyP99[verb] = q
+		}
+	}
+
+	if client.FlowSchema != "" {
+		prev = series(before, "apiserver_flowcontrol_dispatched_requests_total")
+		for key, m := range series(after, "apiserver_flowcontrol_dispatched_requests_total") {
+			// The label was renamed from flowSchema in Kubernetes 1.20.
+			schema, ok := labelValue(m, "flow_schema")
+			if !ok {
+				schema, _ = labelValue(m, "flowSchema")
+			}
+			if schema != client.FlowSchema {
+				continue
+			}
+			filters["flow_schema"] = true
+			if delta := counterValue(m) - counterValue(prev[key]); delta > 0 && seconds > 0 {
+				load.TotalRequests += delta / seconds
+			}
+		}
+	}
+
+	for filter := range filters {
+		load.Filters = append(load.Filters, filter)
+	}
+	sort.Strings(load.Filters)
+	load.Partial = filters["group"]
+	return load
+}
+
+// quantile returns the q quantile of the cumulative histogram buckets, by
+// upper bound, interpolating linearly within buckets like Prometheus
+// histogram_quantile. It returns NaN for an empty histogram.
+func quantile(q float64, buckets map[float64]float64) float64 {
+	var bounds []float64
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	if len(bounds) == 0 {
+		return math.NaN()
+	}
+
+	total := buckets[bounds[len(bounds)-1]]
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := q * total
+	lower, below := 0.0, 0.0
+	for _, bound := range bounds {
+		count := buckets[bound]
+		if count >= rank {
+			if math.IsInf(bound, 1) {
+				// Prometheus returns the upper bound of the highest finite
+				// bucket in that case.
+				return lower
+			}
+			if count == below {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-below)/(count-below)
+		}
+		lower, below = bound, count
+	}
+	return lower
+}
+
+// String summarizes the load for logging.
+func (l Load) String() string {
+	var total float64
+	for _, rate := range l.Requests {
+		total += rate
+	}
+	s := fmt.Sprintf("%.2f requests/s, p99 latency by verb %v (attributed by %s)", total, l.LatencyP99, strings.Join(l.Filters, ", "))
+	if l.Partial {
+		s += ", partial: custom resource requests only"
+	}
+	if l.TotalRequests > 0 {
+		s += fmt.Sprintf(", %.2f requests/s in total", l.TotalRequests)
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package apiload
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func parse(t *testing.T, text string) map[string]*dto.MetricFamily {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+var cilium = Client{UserAgent: "cilium", Group: "cilium.io"}
+
+const before = `# TYPE apiserver_request_total counter
+apiserver_request_total{client="cilium-agent/v1.8.2",code="200",resource="ciliumendpoints",verb="CREATE"} 100
+apiserver_request_total{client="cilium-agent/v1.8.2",code="200",resource="services",verb="WATCH"} 10
+apiserver_request_total{client="kubectl/v1.18.8",code="200",resource="pods",verb="LIST"} 5
+# TYPE apiserver_request_duration_seconds histogram
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="0.1"} 90
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="1"} 100
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="+Inf"} 100
+apiserver_request_duration_seconds_sum{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE"} 5
+apiserver_request_duration_seconds_count{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE"} 100
+`
+
+const after = `# TYPE apiserver_request_total counter
+apiserver_request_total{client="cilium-agent/v1.8.2",code="200",resource="ciliumendpoints",verb="CREATE"} 290
+apiserver_request_total{client="cilium-agent/v1.8.2",code="500",resource="ciliumendpoints",verb="CREATE"} 10
+apiserver_request_total{client="cilium-agent/v1.8.2",code="200",resource="services",verb="WATCH"} 10
+apiserver_request_total{client="kubectl/v1.18.8",code="200",resource="pods",verb="LIST"} 500
+# TYPE apiserver_request_duration_seconds histogram
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="0.1"} 90
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="1"} 300
+apiserver_request_duration_seconds_bucket{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE",le="+Inf"} 300
+apiserver_request_duration_seconds_sum{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE"} 105
+apiserver_request_duration_seconds_count{client="cilium-agent/v1.8.2",resource="ciliumendpoints",verb="CREATE"} 300
+`
+
+func TestDiffByClient(t *testing.T) {
+	load := Diff(parse(t, before), parse(t, after), 100*time.Second, cilium)
+
+	if got := load.Requests["CREATE ciliumendpoints"]; math.Abs(got-2) > 1e-9 {
+		t.Errorf("got %v CREATE requests/s, want 2", got)
+	}
+	if got := load.Errors["CREATE ciliumendpoints"]; math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("got %v CREATE errors/s, want 0.1", got)
+	}
+	if _, ok := load.Requests["LIST pods"]; ok {
+		t.Error("requests of another client accounted")
+	}
+	if _, ok := load.Requests["WATCH services"]; ok {
+		t.Error("idle series accounted")
+	}
+	// All 200 new requests are in the (0.1, 1] bucket.
+	if got, want := load.LatencyP99["CREATE"], 0.1+0.9*0.99; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got p99 %v, want %v", got, want)
+	}
+	if len(load.Filters) != 1 || load.Filters[0] != "client" {
+		t.Errorf("got filters %v", load.Filters)
+	}
+}
+
+func TestDiffByGroup(t *testing.T) {
+	before := parse(t, `apiserver_request_total{code="200",group="cilium.io",resource="ciliumidentities",verb="LIST"} 0
+apiserver_request_total{code="200",group="",resource="pods",verb="LIST"} 0
+`)
+	after := parse(t, `apiserver_request_total{code="200",group="cilium.io",resource="ciliumidentities",verb="LIST"} 60
+apiserver_request_total{code="200",group="",resource="pods",verb="LIST"} 600
+`)
+	load := Diff(before, after, time.Minute, cilium)
+	if len(load.Requests) != 1 || load.Requests["LIST ciliumidentities"] != 1 {
+		t.Errorf("unexpected requests %v", load.Requests)
+	}
+	if len(load.Filters) != 1 || load.Filters[0] != "group" {
+		t.Errorf("got filters %v", load.Filters)
+	}
+	if !load.Partial {
+		t.Error("group-only attribution not marked partial")
+	}
+}
+
+func TestDiffByFlowSchema(t *testing.T) {
+	before := parse(t, `apiserver_request_total{code="200",group="cilium.io",resource="ciliumidentities",verb="LIST"} 0
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="cilium",priorityLevel="workload-low"} 100
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="service-accounts",priorityLevel="workload-low"} 100
+`)
+	after := parse(t, `apiserver_request_total{code="200",group="cilium.io",resource="ciliumidentities",verb="LIST"} 60
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="cilium",priorityLevel="workload-low"} 400
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="service-accounts",priorityLevel="workload-low"} 1000
+`)
+	client := cilium
+	client.FlowSchema = "cilium"
+	load := Diff(before, after, time.Minute, client)
+	if load.TotalRequests != 5 {
+		t.Errorf("got %v requests/s in total, want 5", load.TotalRequests)
+	}
+	if load.Requests["LIST ciliumidentities"] != 1 || !load.Partial {
+		t.Errorf("unexpected requests %v, partial %t", load.Requests, load.Partial)
+	}
+	if len(load.Filters) != 2 || load.Filters[0] != "flow_schema" || load.Filters[1] != "group" {
+		t.Errorf("got filters %v", load.Filters)
+	}
+
+	// Kubernetes 1.20 renamed the label.
+	after = parse(t, `apiserver_flowcontrol_dispatched_requests_total{flow_schema="cilium",priority_level="workload-low"} 60
+`)
+	if load := Diff(nil, after, time.Minute, client); load.TotalRequests != 1 {
+		t.Errorf("got %v requests/s in total, want 1", load.TotalRequests)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	if q := quantile(0.99, nil); !math.IsNaN(q) {
+		t.Errorf("got %v for an empty histogram", q)
+	}
+	buckets := map[float64]float64{0.5: 50, 1: 100, math.Inf(1): 100}
+	if q := quantile(0.5, buckets); q != 0.5 {
+		t.Errorf("got median %v, want 0.5", q)
+	}
+	if q := quantile(0.75, buckets); q != 0.75 {
+		t.Errorf("got p75 %v, want 0.75", q)
+	}
+	buckets[math.Inf(1)] = 200
+	if q := quantile(0.99, buckets); q != 1 {
+		t.Errorf("got p99 %v in the +Inf bucket, want 1", q)
+	}
+}
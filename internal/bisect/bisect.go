@@ -0,0 +1,168 @@
+// Package bisect searches an ordered list of Cilium builds for the first one
+// whose result in a perf test scenario regressed beyond a threshold, compared
+// to the first build of the list.
+package bisect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// ErrNoRegression is returned by First when the last build doesn't exceed the
+// threshold.
+var ErrNoRegression = errors.New("the last build doesn't regress")
+
+// First returns the index of the first of n builds for which exceeds returns
+// true. The first build is assumed not to exceed and the builds past the first
+// one that does are assumed to exceed too, so exceeds is only called on
+// O(log n) builds, starting with the last one.
+func First(n int, exceeds func(i int) (bool, error)) (int, error) {
+	if n < 2 {
+		return 0, fmt.Errorf("need at least 2 builds to bisect, got %d", n)
+	}
+	bad, err := exceeds(n - 1)
+	if err != nil {
+		return 0, err
+	}
+	if !bad {
+		return 0, ErrNoRegression
+	}
+
+	good, first := 0, n-1
+	for first-good > 1 {
+		mid := (good + first) / 2
+		bad, err := exceeds(mid)
+		if err != nil {
+			return 0, err
+		}
+		if bad {
+			first = mid
+		} else {
+			good = mid
+		}
+	}
+	return first, nil
+}
+
+// Regressed tells whether value exceeds base by more than threshold, relative
+// to base. Higher values are worse.
+func Regressed(base, value, threshold float64) bool {
+	return value > base*(1+threshold)
+}
+
+// report holds the parts of the JSON report of a gke run bisect needs.
+type report struct {
+	Cases []struct {
+		Name    string   `json:"name"`
+		Invalid []string `json:"invalid"`
+		Results struct {
+			Metrics map[string]model.Matrix `json:"metrics"`
+		} `json:"results"`
+	} `json:"cases"`
+}
+
+// Value returns the average over the test case scenario of the metric of the
+// report r, e.g. "avg(agent_cpu_cores)". It fails if the measurements of the
+// scenario are flagged as invalid.
+func Value(r io.Reader, scenario, metric string) (float64, error) {
+	var rep report
+	if err := json.NewDecoder(r).Decode(&rep); err != nil {
+		return 0, fmt.Errorf("failed to decode report: %w", err)
+	}
+
+	for _, c := range rep.Cases {
+		if c.Name != scenario {
+			continue
+		}
+		if len(c.Invalid) > 0 {
+			return 0, fmt.Errorf("measurements of %s are invalid: %s", scenario, strings.Join(c.Invalid, ", "))
+		}
+		matrix, ok := c.Results.Metrics[metric]
+		if !ok {
+			return 0, fmt.Errorf("no metric %s in the results of %s", metric, scenario)
+		}
+		var sum float64
+		var count int
+		for _, stream := range matrix {
+			for _, v := range stream.Values {
+				sum += float64(v.Value)
+				count++
+			}
+		}
+		if count == 0 {
+			return 0, fmt.Errorf("no samples of %s in the results of %s", metric, scenario)
+		}
+		return sum / float64(count), nil
+	}
+	return 0, fmt.Errorf("no test case %s in report", scenario)
+}
+
+// Build is the tag or digest of the agent and operator images of a Cilium
+// build.
+type Build struct {
+	Agent, Operator string
+}
+
+// ParseBuild parses a build given as a tag shared by the agent and operator
+// images, e.g. "v1.9.0-rc1", or as "agent=operator" tags or digests. The agent
+// and operator images of a build have different digests, so a single digest is
+// rejected.
+func ParseBuild(s string) (Build, error) {
+	if i := strings.Index(s, "="); i >= 0 {
+		b := Build{Agent: s[:i], Operator: s[i+1:]}
+		if b.Agent == "" || b.Operator == "" {
+			return Build{}, fmt.Errorf("invalid build %q, want agent=operator", s)
+		}
+		return b, nil
+	}
+	if strings.Contains(s, ":") {
+		return Build{}, fmt.Errorf("digest %s can't be both the agent and operator one, pass the build as agent=operator digests", s)
+	}
+	return Build{Agent: s, Operator: s}, nil
+}
+
+func (b Build) String() string {
+	if b.Agent == b.Operator {
+		return b.Agent
+	}
+	return b.Agent + "=" + b.Operator
+}
+
+// Median returns the median of values, which must not be empty.
+func Median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// WithRef returns the image reference image with its tag or digest replaced by
+// ref, e.g. a tag "v1.9.0-rc1" or a digest "sha256:...".
+func WithRef(image, ref string) string {
+	repo := Repository(image)
+	if strings.Contains(ref, ":") {
+		return repo + "@" + ref
+	}
+	return repo + ":" + ref
+}
+
+// Repository returns the image reference image without its tag and digest.
+func Repository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// A colon before the last slash separates the port of the registry.
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
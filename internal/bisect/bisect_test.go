@@ -0,0 +1,150 @@
+package bisect
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirst(t *testing.T) {
+	for n := 2; n < 20; n++ {
+		for want := 1; want < n; want++ {
+			var calls int
+			got, err := First(n, func(i int) (bool, error) {
+				calls++
+				return i >= want, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("n=%d: got first build %d, want %d", n, got, want)
+			}
+			if max := int(math.Ceil(math.Log2(float64(n)))) + 1; calls > max {
+				t.Errorf("n=%d: %d builds tested, want at most %d", n, calls, max)
+			}
+		}
+	}
+}
+
+func TestFirstNoRegression(t *testing.T) {
+	_, err := First(5, func(i int) (bool, error) { return false, nil })
+	if !errors.Is(err, ErrNoRegression) {
+		t.Errorf("got error %v, want ErrNoRegression", err)
+	}
+	if _, err := First(1, nil); err == nil {
+		t.Error("bisecting a single build didn't fail")
+	}
+}
+
+func TestRegressed(t *testing.T) {
+	if Regressed(1, 1.09, 0.1) {
+		t.Error("a 9% increase regressed with a 10% threshold")
+	}
+	if !Regressed(1, 1.2, 0.1) {
+		t.Error("a 20% increase didn't regress with a 10% threshold")
+	}
+}
+
+const testReport = `{
+  "start": "2020-09-01T00:00:00Z",
+  "cases": [
+    {
+      "name": "baseline",
+      "results": {"metrics": {"avg(agent_cpu_cores)": [{"metric": {}, "values": [[1598918400, "9"]]}]}}
+    },
+    {
+      "name": "big-load",
+      "results": {
+        "metrics": {
+          "avg(agent_cpu_cores)": [{"metric": {}, "values": [[1598918400, "1"], [1598918460, "2"], [1598918520, "3"]]}]
+        }
+      }
+    },
+    {
+      "name": "saturation",
+      "invalid": ["noisy node"],
+      "results": {"metrics": {"avg(agent_cpu_cores)": [{"metric": {}, "values": [[1598918400, "1"]]}]}}
+    }
+  ]
+}`
+
+func TestValue(t *testing.T) {
+	v, err := Value(strings.NewReader(testReport), "big-load", "avg(agent_cpu_cores)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Errorf("got %v, want 2", v)
+	}
+
+	for _, c := range []struct{ scenario, metric string }{
+		{"big-load", "max(agent_cpu_cores)"},
+		{"small-load", "avg(agent_cpu_cores)"},
+		{"saturation", "avg(agent_cpu_cores)"},
+	} {
+		if _, err := Value(strings.NewReader(testReport), c.scenario, c.metric); err == nil {
+			t.Errorf("getting %s of %s didn't fail", c.metric, c.scenario)
+		}
+	}
+}
+
+func TestWithRef(t *testing.T) {
+	tests := []struct {
+		image, ref, want string
+	}{
+		{"docker.io/cilium/cilium:v1.8.2", "v1.8.3", "docker.io/cilium/cilium:v1.8.3"},
+		{"docker.io/cilium/cilium", "latest", "docker.io/cilium/cilium:latest"},
+		{"localhost:5000/cilium/cilium:v1.8.2", "v1.8.3", "localhost:5000/cilium/cilium:v1.8.3"},
+		{"localhost:5000/cilium/cilium", "v1.8.3", "localhost:5000/cilium/cilium:v1.8.3"},
+		{"quay.io/cilium/cilium@sha256:abc", "sha256:def", "quay.io/cilium/cilium@sha256:def"},
+		{"quay.io/cilium/cilium:v1.8.2@sha256:abc", "v1.8.3", "quay.io/cilium/cilium:v1.8.3"},
+	}
+	for _, tt := range tests {
+		if got := WithRef(tt.image, tt.ref); got != tt.want {
+			t.Errorf("WithRef(%q, %q) = %q, want %q", tt.image, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestParseBuild(t *testing.T) {
+	tests := map[string]Build{
+		"v1.9.0-rc1":                    {Agent: "v1.9.0-rc1", Operator: "v1.9.0-rc1"},
+		"sha256:abc=sha256:def":         {Agent: "sha256:abc", Operator: "sha256:def"},
+		"v1.9.0-rc1=v1.9.0-rc1-generic": {Agent: "v1.9.0-rc1", Operator: "v1.9.0-rc1-generic"},
+	}
+	for s, want := range tests {
+		got, err := ParseBuild(s)
+		if err != nil {
+			t.Errorf("ParseBuild(%q): %s", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseBuild(%q) = %+v, want %+v", s, got, want)
+		}
+		if got.String() != s {
+			t.Errorf("%+v formatted as %q, want %q", got, got.String(), s)
+		}
+	}
+
+	for _, s := range []string{"sha256:abc", "=sha256:def", "sha256:abc="} {
+		if _, err := ParseBuild(s); err == nil {
+			t.Errorf("ParseBuild(%q) didn't fail", s)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	if got := Median([]float64{3, 1, 2}); got != 2 {
+		t.Errorf("got median %v, want 2", got)
+	}
+	values := []float64{4, 1, 3, 2}
+	if got := Median(values); got != 2.5 {
+		t.Errorf("got median %v, want 2.5", got)
+	}
+	if !reflect.DeepEqual(values, []float64{4, 1, 3, 2}) {
+		t.Errorf("values reordered: %v", values)
+	}
+}
@@ -0,0 +1,215 @@
+// Package images rewrites the container images of the manifests the tests
+// apply, e.g. to pull them from a local registry in air-gapped environments or
+// to use images loaded into kind, and pre-pulls them on every node so that
+// image pulls don't add up to the measurements.
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/cilium/cilium-perf-test/internal/yamldoc"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Mapping rewrites image references. The zero Mapping, like a nil one, keeps
+// them as they are.
+type Mapping struct {
+	// Registry replaces the registry of the images not matched by Images,
+	// e.g. "localhost:5000".
+	Registry string `json:"registry"`
+	// Images maps image references to their replacement. A key ending with
+	// "/" or ":" is a prefix, e.g. "docker.io/cilium/" or "glibsm/abchain:",
+	// replaced by the value. Images without a registry are Docker Hub ones,
+	// e.g. "busybox:1.32" is "docker.io/library/busybox:1.32". The longest
+	// matching key wins.
+	Images map[string]string `json:"images"`
+	// PullPolicy is set on the containers whose image is rewritten, if not
+	// empty, e.g. "Never" for images loaded into kind.
+	PullPolicy corev1.PullPolicy `json:"pull_policy"`
+}
+
+// Load reads the YAML mapping file path.
+func Load(path string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image mapping: %w", err)
+	}
+	var m Mapping
+	if err := sigsyaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode image mapping %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Normalize returns the fully qualified form of the image reference image,
+// adding the implicit Docker Hub registry and library namespace.
+func Normalize(image string) string {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return "docker.io/library/" + image
+	}
+	if host := image[:slash]; !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io/" + image
+	}
+	return image
+}
+
+// Rewrite returns the reference image is mapped to.
+func (m *Mapping) Rewrite(image string) string {
+	if m == nil {
+		return image
+	}
+	normalized := Normalize(image)
+
+	var match, matchKey string
+	for from := range m.Images {
+		key := Normalize(from)
+		prefix := strings.HasSuffix(key, "/") || strings.HasSuffix(key, ":")
+		if (key == normalized || prefix && strings.HasPrefix(normalized, key)) && len(key) > len(matchKey) {
+			match, matchKey = from, key
+		}
+	}
+	if match != "" {
+		return m.Images[match] + strings.TrimPrefix(normalized, matchKey)
+	}
+
+	if m.Registry != "" {
+		return m.Registry + normalized[strings.Index(normalized, "/"):]
+	}
+	return image
+}
+
+// PodSpec rewrites the images of the containers of spec.
+func (m *Mapping) PodSpec(spec *corev1.PodSpec) {
+	if m == nil {
+		return
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			image := m.Rewrite(containers[i].Image)
+			if image == containers[i].Image {
+				continue
+			}
+			containers[i].Image = image
+			if m.PullPolicy != "" {
+				containers[i].ImagePullPolicy = m.PullPolicy
+			}
+		}
+	}
+}
+
+// Object rewrites the images of the pod template of obj, if it has one.
+func (m *Mapping) Object(obj runtime.Object) {
+	if spec := PodSpecOf(obj); spec != nil {
+		m.PodSpec(spec)
+	}
+}
+
+// PodSpecOf returns the pod spec of obj, or of its pod template, or nil if it
+// has none.
+func PodSpecOf(obj runtime.Object) *corev1.PodSpec {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return &o.Spec
+	case *corev1.ReplicationController:
+		if o.Spec.Template != nil {
+			return &o.Spec.Template.Spec
+		}
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec
+	case *appsv1.ReplicaSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec
+	case *batchv1beta1.CronJob:
+		return &o.Spec.JobTemplate.Spec.Template.Spec
+	}
+	return nil
+}
+
+// decode calls fn with each object of the YAML manifest known to client-go
+// and its document. The other documents, e.g. Cilium custom resources, are
+// passed with a nil object.
+func decode(manifest io.Reader, fn func(obj runtime.Object, doc []byte) error) error {
+	docs, err := yamldoc.Read(manifest)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+		if runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) {
+			obj, err = nil, nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if err := fn(obj, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Manifest returns the YAML manifest with the images of its pod templates
+// rewritten, e.g. to pipe it to kubectl apply. The documents without a pod
+// template are kept as they are.
+func (m *Mapping) Manifest(manifest io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	err := decode(manifest, func(obj runtime.Object, doc []byte) error {
+		if out.Len() > 0 {
+			out.WriteString("---\n")
+		}
+		if m == nil || PodSpecOf(obj) == nil {
+			out.Write(doc)
+			return nil
+		}
+		m.Object(obj)
+		data, err := sigsyaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest: %w", err)
+		}
+		out.Write(data)
+		return nil
+	})
+	return out.Bytes(), err
+}
+
+// List returns the images of the containers of the pod templates of the YAML
+// manifest, sorted and without duplicates.
+func List(manifest io.Reader) ([]string, error) {
+	seen := make(map[string]bool)
+	err := decode(manifest, func(obj runtime.Object, _ []byte) error {
+		if spec := PodSpecOf(obj); spec != nil {
+			for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+				for _, c := range containers {
+					seen[c.Image] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
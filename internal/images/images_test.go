@@ -0,0 +1,203 @@
+package images
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"busybox:1.32":                           "docker.io/library/busybox:1.32",
+		"glibsm/abchain:0.0.2":                   "docker.io/glibsm/abchain:0.0.2",
+		"docker.io/cilium/cilium:v1.8.2":         "docker.io/cilium/cilium:v1.8.2",
+		"k8s.gcr.io/pause:3.2":                   "k8s.gcr.io/pause:3.2",
+		"localhost:5000/cilium/cilium":           "localhost:5000/cilium/cilium",
+		"localhost/cilium/cilium:dev":            "localhost/cilium/cilium:dev",
+		"gcr.io/google-samples/frontend@sha256:": "gcr.io/google-samples/frontend@sha256:",
+	}
+	for image, want := range tests {
+		if got := Normalize(image); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	m := &Mapping{
+		Registry: "registry.local:5000",
+		Images: map[string]string{
+			"docker.io/cilium/":       "localhost:5000/cilium/",
+			"docker.io/cilium/cilium": "cilium/cilium:dev",
+			"glibsm/abchain:":         "abchain:",
+			"redis:alpine":            "localhost:5000/redis:6",
+		},
+	}
+	tests := map[string]string{
+		"docker.io/cilium/operator-generic:v1.8.2":                 "localhost:5000/cilium/operator-generic:v1.8.2",
+		"docker.io/cilium/cilium":                                  "cilium/cilium:dev",
+		"docker.io/cilium/cilium:v1.8.2":                           "localhost:5000/cilium/cilium:v1.8.2",
+		"glibsm/abchain:0.0.2":                                     "abchain:0.0.2",
+		"redis:alpine":                                             "localhost:5000/redis:6",
+		"busybox:1.32":                                             "registry.local:5000/library/busybox:1.32",
+		"gcr.io/google-samples/microservices-demo/frontend:v0.2.0": "registry.local:5000/google-samples/microservices-demo/frontend:v0.2.0",
+	}
+	for image, want := range tests {
+		if got := m.Rewrite(image); got != want {
+			t.Errorf("Rewrite(%q) = %q, want %q", image, got, want)
+		}
+	}
+
+	var nilMapping *Mapping
+	if got := nilMapping.Rewrite("busybox:1.32"); got != "busybox:1.32" {
+		t.Errorf("nil mapping rewrote busybox:1.32 to %q", got)
+	}
+	if got := (&Mapping{}).Rewrite("busybox:1.32"); got != "busybox:1.32" {
+		t.Errorf("empty mapping rewrote busybox:1.32 to %q", got)
+	}
+}
+
+const manifest = `apiVersion: v1
+kind: Service
+metadata:
+  name: port-abc
+spec:
+  ports:
+  - port: 3770
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: abchain
+spec:
+  selector:
+    matchLabels:
+      app: abchain
+  template:
+    metadata:
+      labels:
+        app: abchain
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.32
+      containers:
+      - name: a
+        image: glibsm/abchain:0.0.2
+      - name: pause
+        image: k8s.gcr.io/pause:3.2
+---
+apiVersion: cilium.io/v2
+kind: CiliumNetworkPolicy
+metadata:
+  name: l7
+`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mapping.yaml")
+	if err := ioutil.WriteFile(path, []byte(`registry: localhost:5000
+images:
+  glibsm/abchain:0.0.2: abchain:dev
+pull_policy: IfNotPresent
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Mapping{
+		Registry:   "localhost:5000",
+		Images:     map[string]string{"glibsm/abchain:0.0.2": "abchain:dev"},
+		PullPolicy: corev1.PullIfNotPresent,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	m := &Mapping{
+		Images:     map[string]string{"glibsm/": "localhost:5000/glibsm/"},
+		PullPolicy: corev1.PullNever,
+	}
+	out, err := m.Manifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"kind: Service",
+		"kind: Deployment",
+		"image: localhost:5000/glibsm/abchain:0.0.2\n        imagePullPolicy: Never",
+		"image: k8s.gcr.io/pause:3.2\n",
+		"kind: CiliumNetworkPolicy",
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("%q missing from rewritten manifest:\n%s", s, out)
+		}
+	}
+
+	images, err := List(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"busybox:1.32", "k8s.gcr.io/pause:3.2", "localhost:5000/glibsm/abchain:0.0.2"}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("got images %v, want %v", images, want)
+	}
+}
+
+func TestPulled(t *testing.T) {
+	pod := func(states ...corev1.ContainerStatus) *corev1.Pod {
+		p := &corev1.Pod{}
+		p.Spec.Containers = make([]corev1.Container, 2)
+		p.Status.ContainerStatuses = states
+		return p
+	}
+	waiting := func(reason string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}}
+	}
+	pulled := corev1.ContainerStatus{ImageID: "docker-pullable://busybox@sha256:abc"}
+
+	tests := []struct {
+		name   string
+		pod    *corev1.Pod
+		pulled bool
+		err    bool
+	}{
+		{"no status yet", pod(), false, false},
+		{"pulling", pod(pulled, waiting("ContainerCreating")), false, false},
+		{"crashing after the pull", pod(pulled, waiting("CrashLoopBackOff")), true, false},
+		{"pull failed", pod(pulled, waiting("ImagePullBackOff")), false, true},
+		{"all pulled", pod(pulled, pulled), true, false},
+	}
+	for _, tt := range tests {
+		got, err := Pulled(tt.pod)
+		if got != tt.pulled || (err != nil) != tt.err {
+			t.Errorf("%s: got %v, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestPrePullDaemonSet(t *testing.T) {
+	ds := PrePullDaemonSet("prepull", []string{"busybox:1.32", "glibsm/abchain:0.0.2"}, "")
+	containers := ds.Spec.Template.Spec.Containers
+	if len(containers) != 2 || containers[1].Image != "glibsm/abchain:0.0.2" || containers[1].ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Errorf("unexpected containers %+v", containers)
+	}
+	if !ds.Spec.Template.Spec.HostNetwork {
+		t.Error("pre-pull pods need the host network to run before the CNI")
+	}
+}
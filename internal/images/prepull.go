@@ -0,0 +1,119 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PrePullLabel is the label of the pods of the pre-pull DaemonSet.
+const PrePullLabel = "image-prepull"
+
+// pullFailures are the reasons of waiting containers whose image can't be
+// pulled.
+var pullFailures = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// PrePullDaemonSet returns a DaemonSet pulling images on every node, including
+// the ones not ready yet, e.g. before a CNI is deployed. Each image is run as a
+// container sleeping, or failing to if it has no sleep command, which doesn't
+// matter once it is pulled.
+func PrePullDaemonSet(name string, images []string, pullPolicy corev1.PullPolicy) *appsv1.DaemonSet {
+	if pullPolicy == "" {
+		pullPolicy = corev1.PullIfNotPresent
+	}
+	labels := map[string]string{"app": PrePullLabel}
+	var containers []corev1.Container
+	for i, image := range images {
+		containers = append(containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: pullPolicy,
+			Command:         []string{"sleep", "3600"},
+		})
+	}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostNetwork: true,
+					Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					Containers:  containers,
+				},
+			},
+		},
+	}
+}
+
+// Pulled tells whether the images of all the containers of pod are pulled. It
+// returns an error if one of them can't be.
+func Pulled(pod *corev1.Pod) (bool, error) {
+	statuses := pod.Status.ContainerStatuses
+	if len(statuses) < len(pod.Spec.Containers) {
+		return false, nil
+	}
+	for _, s := range statuses {
+		if s.ImageID != "" || s.State.Running != nil || s.State.Terminated != nil {
+			continue
+		}
+		if w := s.State.Waiting; w != nil {
+			if pullFailures[w.Reason] {
+				return false, fmt.Errorf("failed to pull %s on %s: %s: %s", s.Image, pod.Spec.NodeName, w.Reason, w.Message)
+			}
+			// Reasons other than ContainerCreating, e.g.
+			// CrashLoopBackOff, come after the image is pulled.
+			if w.Reason != "" && w.Reason != "ContainerCreating" {
+				continue
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// WaitPrePull waits for the pods of the pre-pull DaemonSet name in namespace
+// to have pulled their images on every node.
+func WaitPrePull(client kubernetes.Interface, namespace, name string, timeout time.Duration) error {
+	var pending []string
+	err := wait.Poll(5*time.Second, timeout, func() (bool, error) {
+		ds, err := client.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		pods, err := client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=" + PrePullLabel})
+		if err != nil {
+			return false, err
+		}
+		pending = nil
+		for i := range pods.Items {
+			pulled, err := Pulled(&pods.Items[i])
+			if err != nil {
+				return false, err
+			}
+			if !pulled {
+				pending = append(pending, pods.Items[i].Spec.NodeName)
+			}
+		}
+		return ds.Status.DesiredNumberScheduled > 0 &&
+			len(pods.Items) == int(ds.Status.DesiredNumberScheduled) &&
+			len(pending) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("images not pulled after %v on nodes %s", timeout, strings.Join(pending, ", "))
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Package imbalance finds the nodes on which a metric is much higher than on
+// the others, e.g. one agent using 3 times the CPU of the others, which tells
+// a regression local to the datapath of a node from a cluster wide one.
+package imbalance
+
+import (
+	"sort"
+)
+
+// Finding is a metric much higher on a node than on the others.
+type Finding struct {
+	Metric string  `json:"metric"`
+	Node   string  `json:"node"`
+	Value  float64 `json:"value"`
+	// Median is the median of the metric on the other nodes.
+	Median float64 `json:"median"`
+	Ratio  float64 `json:"ratio"`
+}
+
+// Detect returns the nodes whose value of a metric is at least ratio times the
+// median of the other nodes, sorted by metric and node. values holds the value
+// of each metric by node. Metrics reported by less than 2 nodes, and nodes
+// whose peers have a median of 0, are skipped.
+func Detect(values map[string]map[string]float64, ratio float64) []Finding {
+	var findings []Finding
+	for metric, byNode := range values {
+		if len(byNode) < 2 {
+			continue
+		}
+		for node, value := range byNode {
+			var others []float64
+			for other, v := range byNode {
+				if other != node {
+					others = append(others, v)
+				}
+			}
+			m := median(others)
+			if m <= 0 || value < ratio*m {
+				continue
+			}
+			findings = append(findings, Finding{
+				Metric: metric,
+				Node:   node,
+				Value:  value,
+				Median: m,
+				Ratio:  value / m,
+			})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Metric != findings[j].Metric {
+			return findings[i].Metric < findings[j].Metric
+		}
+		return findings[i].Node < findings[j].Node
+	})
+	return findings
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,43 @@
+package imbalance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	values := map[string]map[string]float64{
+		"agent_cpu_cores": {
+			"node-a": 0.1,
+			"node-b": 0.12,
+			"node-c": 0.33,
+		},
+		"agent_resident_memory_bytes": {
+			"node-a": 200e6,
+			"node-b": 210e6,
+			"node-c": 205e6,
+		},
+		// Idle peers don't make a node imbalanced.
+		"policy_regenerations_per_second": {
+			"node-a": 0,
+			"node-b": 0,
+			"node-c": 1,
+		},
+		// The operator runs on a single node.
+		"operator_cpu_cores": {
+			"node-b": 0.05,
+		},
+	}
+
+	got := Detect(values, 3)
+	want := []Finding{
+		{Metric: "agent_cpu_cores", Node: "node-c", Value: 0.33, Median: 0.11, Ratio: 0.33 / 0.11},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := Detect(values, 4); len(got) != 0 {
+		t.Errorf("got findings %+v above the ratio", got)
+	}
+}
@@ -0,0 +1,53 @@
+// Package latency summarizes latency distributions.
+package latency
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Sort sorts latencies in increasing order.
+func Sort(latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+}
+
+// Percentile returns the q-quantile (0 <= q <= 1) of sorted, using the nearest
+// rank method.
+func Percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Distribution summarizes a latency distribution.
+type Distribution struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// Summarize returns the distribution of sorted.
+func Summarize(sorted []time.Duration) Distribution {
+	return Distribution{
+		Count: len(sorted),
+		P50:   Percentile(sorted, 0.5),
+		P90:   Percentile(sorted, 0.9),
+		P99:   Percentile(sorted, 0.99),
+		Max:   Percentile(sorted, 1),
+	}
+}
+
+func (d Distribution) String() string {
+	return fmt.Sprintf("p50=%v p90=%v p99=%v p100=%v", d.P50, d.P90, d.P99, d.Max)
+}
@@ -0,0 +1,37 @@
+package latency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Second)
+	}
+	tests := map[float64]time.Duration{
+		0:    time.Second,
+		0.5:  5 * time.Second,
+		0.9:  9 * time.Second,
+		0.99: 10 * time.Second,
+		1:    10 * time.Second,
+	}
+	for q, want := range tests {
+		if got := Percentile(sorted, q); got != want {
+			t.Errorf("Percentile(%g) = %v, want %v", q, got, want)
+		}
+	}
+	if got := Percentile(nil, 0.5); got != 0 {
+		t.Errorf("Percentile of no latencies = %v, want 0", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	latencies := []time.Duration{3 * time.Second, time.Second, 2 * time.Second}
+	Sort(latencies)
+	want := Distribution{Count: 3, P50: 2 * time.Second, P90: 3 * time.Second, P99: 3 * time.Second, Max: 3 * time.Second}
+	if got := Summarize(latencies); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package ledger
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Deleter returns a function deleting the object of an entry with client, to
+// be passed to Teardown. Objects that are already gone are ignored.
+func Deleter(client dynamic.Interface) func(Entry) error {
+	return func(e Entry) error {
+		gvr := schema.GroupVersionResource{Group: e.Group, Version: e.Version, Resource: e.Resource}
+		propagation := metav1.DeletePropagationBackground
+		err := client.Resource(gvr).Namespace(e.Namespace).Delete(context.TODO(), e.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+}
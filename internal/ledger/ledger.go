@@ -0,0 +1,159 @@
+// Package ledger records the Kubernetes objects created by a perf test run in
+// a file, so that they can be deleted when the run completes, when it is
+// interrupted, or later by the cleanup command if the run was killed.
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Entry identifies an object created by a run.
+type Entry struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	// Namespace is empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (e Entry) String() string {
+	resource := e.Resource
+	if e.Group != "" {
+		resource += "." + e.Group
+	}
+	if e.Namespace == "" {
+		return resource + "/" + e.Name
+	}
+	return resource + "/" + e.Namespace + "/" + e.Name
+}
+
+// Ledger is the list of objects created by a run, in creation order, persisted
+// to a file with one JSON encoded entry per line.
+type Ledger struct {
+	mu      sync.Mutex
+	path    string
+	entries []Entry
+}
+
+// Open loads the ledger stored at path, which may not exist yet.
+func Open(path string) (*Ledger, error) {
+	l := &Ledger{path: path}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// The run may have been killed while writing the entry.
+			continue
+		}
+		l.entries = append(l.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return l, nil
+}
+
+// Record adds e to the ledger. It must be called as soon as the object is
+// created.
+func (l *Ledger) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to record %s: %w", e, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to record %s: %w", e, err)
+	}
+
+	l.entries = append(l.entries, e)
+	return nil
+}
+
+// Entries returns the objects recorded in the ledger, in creation order.
+func (l *Ledger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Entry(nil), l.entries...)
+}
+
+// Teardown deletes the objects of the ledger with del, in reverse creation
+// order. The objects del fails to delete are kept in the ledger, the ledger
+// file is removed once it is empty.
+func (l *Ledger) Teardown(del func(Entry) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		remaining []Entry
+		firstErr  error
+	)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if err := del(l.entries[i]); err != nil {
+			remaining = append([]Entry{l.entries[i]}, remaining...)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete %s: %w", l.entries[i], err)
+			}
+		}
+	}
+	l.entries = remaining
+
+	if err := l.save(); err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("%d objects could not be deleted, first error: %w", len(remaining), firstErr)
+	}
+	return nil
+}
+
+// save rewrites the ledger file with the current entries.
+func (l *Ledger) save() error {
+	if len(l.entries) == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove ledger: %w", err)
+		}
+		return nil
+	}
+
+	f, err := os.Create(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range l.entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to write ledger: %w", err)
+		}
+	}
+	return f.Sync()
+}
@@ -0,0 +1,100 @@
+package ledger
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var entries = []Entry{
+	{Version: "v1", Resource: "namespaces", Name: "cilium-perf"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles", Name: "cilium"},
+	{Group: "apps", Version: "v1", Resource: "daemonsets", Namespace: "cilium-perf", Name: "cilium"},
+}
+
+func tempLedger(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "ledger.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func TestRecordAndOpen(t *testing.T) {
+	path, cleanup := tempLedger(t)
+	defer cleanup()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A run killed while recording an entry leaves a partial line behind.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"version":"v1","reso`)
+	f.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Entries(); !reflect.DeepEqual(got, entries) {
+		t.Errorf("got entries %v, want %v", got, entries)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	path, cleanup := tempLedger(t)
+	defer cleanup()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deleted []Entry
+	err = l.Teardown(func(e Entry) error {
+		if e.Resource == "clusterroles" {
+			return errors.New("forbidden")
+		}
+		deleted = append(deleted, e)
+		return nil
+	})
+	if err == nil {
+		t.Error("failed deletion not reported")
+	}
+	if want := []Entry{entries[2], entries[0]}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reopened.Entries(), []Entry{entries[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got remaining entries %v, want %v", got, want)
+	}
+
+	if err := reopened.Teardown(func(Entry) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("empty ledger file not removed")
+	}
+}
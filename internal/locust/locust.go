@@ -0,0 +1,220 @@
+// Package locust parses the statistics Locust prints when running without its
+// web UI, as the microservices demo load generator does.
+package locust
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregated is the name of the statistics summing up all endpoints.
+const Aggregated = "Aggregated"
+
+// Stats are the statistics of the requests sent to one endpoint.
+type Stats struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"`
+	Failures int64  `json:"failures"`
+	// RPS is the current number of requests per second.
+	RPS           float64       `json:"rps"`
+	AvgLatency    time.Duration `json:"avg_latency"`
+	MinLatency    time.Duration `json:"min_latency"`
+	MaxLatency    time.Duration `json:"max_latency"`
+	MedianLatency time.Duration `json:"median_latency"`
+	// Percentiles holds the latency percentiles by percentile, e.g. "95%".
+	// Locust only prints them when it stops.
+	Percentiles map[string]time.Duration `json:"percentiles,omitempty"`
+}
+
+// Snapshot is a statistics table. Locust prints one every couple of seconds,
+// the counters are cumulated since the start of the run.
+type Snapshot struct {
+	Endpoints  []Stats `json:"endpoints"`
+	Aggregated Stats   `json:"aggregated"`
+}
+
+// Report holds all the statistics found in the Locust output.
+type Report struct {
+	Snapshots []Snapshot `json:"-"`
+	// Final is the last snapshot, with the latency percentiles added to it if
+	// Locust printed them.
+	Final Snapshot `json:"final"`
+}
+
+var (
+	// GET /cart     123     4(3.15%)     47      18     134  |      41    0.90    0.00
+	statsRow = regexp.MustCompile(`^\s*(.+?)\s+(\d+)\s+(\d+)\([\d.]+%\)\s+(\d+)\s+(\d+)\s+(\d+)\s+\|\s+(\d+)\s+([\d.]+)`)
+	// GET /cart     123     21     24     27 ...
+	percentilesRow = regexp.MustCompile(`^\s*(.+?)\s+(\d+)((?:\s+\d+)+)\s*$`)
+)
+
+// Parse reads the Locust output from r.
+func Parse(r io.Reader) (*Report, error) {
+	var (
+		report      Report
+		percentiles map[string]map[string]time.Duration
+		// columns are the percentiles of the percentile table being read.
+		columns []string
+		// table is the kind of table being read, if any.
+		table     string
+		separator int
+		snapshot  Snapshot
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+
+		switch {
+		case len(fields) > 2 && (fields[0] == "Name" || fields[0] == "Type") && strings.HasSuffix(fields[len(fields)-1], "%"):
+			table, separator = "percentiles", 0
+			percentiles = make(map[string]map[string]time.Duration)
+			columns = nil
+			for _, f := range fields {
+				if strings.HasSuffix(f, "%") {
+					columns = append(columns, f)
+				}
+			}
+			continue
+		case len(fields) > 2 && fields[0] == "Name" && fields[1] == "#":
+			table, separator, snapshot = "stats", 0, Snapshot{}
+			continue
+		case table == "":
+			continue
+		case strings.HasPrefix(strings.TrimSpace(line), "---"):
+			separator++
+			continue
+		case strings.TrimSpace(line) == "":
+			table = ""
+			continue
+		}
+
+		switch table {
+		case "stats":
+			m := statsRow.FindStringSubmatch(line)
+			if m == nil {
+				table = ""
+				continue
+			}
+			stats, err := parseStats(m)
+			if err != nil {
+				return nil, err
+			}
+			if separator > 1 || stats.Name == Aggregated {
+				snapshot.Aggregated = stats
+				report.Snapshots = append(report.Snapshots, snapshot)
+				table = ""
+			} else {
+				snapshot.Endpoints = append(snapshot.Endpoints, stats)
+			}
+
+		case "percentiles":
+			m := percentilesRow.FindStringSubmatch(line)
+			if m == nil {
+				table = ""
+				continue
+			}
+			values := strings.Fields(m[3])
+			if len(values) != len(columns) {
+				return nil, fmt.Errorf("percentile row %q has %d values, expected %d", line, len(values), len(columns))
+			}
+			name := endpointName(m[1])
+			if separator > 1 {
+				name = Aggregated
+			}
+			percentiles[name] = make(map[string]time.Duration)
+			for i, v := range values {
+				ms, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid percentile %q: %w", v, err)
+				}
+				percentiles[name][columns[i]] = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Locust output: %w", err)
+	}
+
+	if len(report.Snapshots) == 0 {
+		return nil, fmt.Errorf("no statistics found in Locust output")
+	}
+
+	report.Final = report.Snapshots[len(report.Snapshots)-1]
+	for i := range report.Final.Endpoints {
+		report.Final.Endpoints[i].Percentiles = percentiles[report.Final.Endpoints[i].Name]
+	}
+	report.Final.Aggregated.Percentiles = percentiles[Aggregated]
+
+	return &report, nil
+}
+
+// endpointName normalizes the name of an endpoint. Depending on the Locust
+// version, the method and path are separated by a single space or are in two
+// columns, and the aggregated statistics are called "Total" or "Aggregated".
+func endpointName(s string) string {
+	name := strings.Join(strings.Fields(s), " ")
+	if name == "Total" || strings.HasSuffix(name, " "+Aggregated) {
+		return Aggregated
+	}
+	return name
+}
+
+func parseStats(m []string) (Stats, error) {
+	ints := make([]int64, 6)
+	for i := range ints {
+		v, err := strconv.ParseInt(m[i+2], 10, 64)
+		if err != nil {
+			return Stats{}, fmt.Errorf("invalid statistics %q: %w", m[0], err)
+		}
+		ints[i] = v
+	}
+	rps, err := strconv.ParseFloat(m[8], 64)
+	if err != nil {
+		return Stats{}, fmt.Errorf("invalid statistics %q: %w", m[0], err)
+	}
+
+	return Stats{
+		Name:          endpointName(m[1]),
+		Requests:      ints[0],
+		Failures:      ints[1],
+		AvgLatency:    time.Duration(ints[2]) * time.Millisecond,
+		MinLatency:    time.Duration(ints[3]) * time.Millisecond,
+		MaxLatency:    time.Duration(ints[4]) * time.Millisecond,
+		MedianLatency: time.Duration(ints[5]) * time.Millisecond,
+		RPS:           rps,
+	}, nil
+}
+
+// ErrorRateRising returns whether the share of failed requests was higher in
+// the second half of the run than in the first half, by more than threshold
+// (e.g. 0.01 for 1%).
+func (r *Report) ErrorRateRising(threshold float64) bool {
+	if len(r.Snapshots) < 3 {
+		return false
+	}
+
+	first := r.Snapshots[0].Aggregated
+	middle := r.Snapshots[len(r.Snapshots)/2].Aggregated
+	last := r.Snapshots[len(r.Snapshots)-1].Aggregated
+
+	return errorRate(first, middle)+threshold < errorRate(middle, last)
+}
+
+// errorRate returns the share of the requests sent between from and to that
+// failed.
+func errorRate(from, to Stats) float64 {
+	requests := to.Requests - from.Requests
+	failures := to.Failures - from.Failures
+	if requests <= 0 || failures < 0 {
+		// Statistics were reset in between.
+		return 0
+	}
+	return float64(failures) / float64(requests)
+}
@@ -0,0 +1,80 @@
+package locust
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const output = `[2020-08-20 10:00:00,000] loadgenerator/INFO/locust.main: Starting Locust 0.13.5
+ Name                                                          # reqs      # fails     Avg     Min     Max  |  Median   req/s failures/s
+--------------------------------------------------------------------------------------------------------------------------------------------
+ GET /                                                             10     0(0.00%)      40      18     134  |      41    1.00    0.00
+ POST /cart                                                        10     0(0.00%)      50      20     100  |      45    1.00    0.00
+--------------------------------------------------------------------------------------------------------------------------------------------
+ Aggregated                                                        20     0(0.00%)      45      18     134  |      43    2.00    0.00
+
+ Name                                                          # reqs      # fails     Avg     Min     Max  |  Median   req/s failures/s
+--------------------------------------------------------------------------------------------------------------------------------------------
+ GET /                                                             60     2(3.33%)      40      18     134  |      41    5.00    0.10
+ POST /cart                                                        40     0(0.00%)      50      20     100  |      45    5.00    0.00
+--------------------------------------------------------------------------------------------------------------------------------------------
+ Aggregated                                                       100     2(2.00%)      45      18     134  |      43   10.00    0.10
+
+ Name                                                          # reqs      # fails     Avg     Min     Max  |  Median   req/s failures/s
+--------------------------------------------------------------------------------------------------------------------------------------------
+ GET /                                                            120    32(26.67%)      40      18     134  |      41    5.00    3.00
+ POST /cart                                                        80     0(0.00%)      50      20     100  |      45    5.00    0.00
+--------------------------------------------------------------------------------------------------------------------------------------------
+ Aggregated                                                       200    32(16.00%)      45      18     134  |      43   10.00    3.00
+
+Percentage of the requests completed within given times
+ Type                 Name                                                           # reqs    50%    66%    75%    80%    90%    95%    98%    99%  99.9% 99.99%   100%
+------------------------------------------------------------------------------------------------------------------------------------------------------
+ GET                  /                                                                 120     41     45     50     55     60     70     80     90    130    134    134
+ POST                 /cart                                                              80     45     50     55     60     70     80     90     95    100    100    100
+------------------------------------------------------------------------------------------------------------------------------------------------------
+ None                 Aggregated                                                        200     43     47     52     57     65     75     85     92    130    134    134
+`
+
+func TestParse(t *testing.T) {
+	report, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Snapshots) != 3 {
+		t.Fatalf("got %d snapshots, want 3", len(report.Snapshots))
+	}
+
+	final := report.Final
+	if len(final.Endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2", len(final.Endpoints))
+	}
+	get := final.Endpoints[0]
+	if get.Name != "GET /" || get.Requests != 120 || get.Failures != 32 || get.RPS != 5 {
+		t.Errorf("unexpected stats for GET /: %+v", get)
+	}
+	if got := get.Percentiles["99%"]; got != 90*time.Millisecond {
+		t.Errorf("got p99 %v for GET /, want 90ms", got)
+	}
+	if got := final.Aggregated.Percentiles["95%"]; got != 75*time.Millisecond {
+		t.Errorf("got aggregated p95 %v, want 75ms", got)
+	}
+	if final.Aggregated.Requests != 200 {
+		t.Errorf("got %d aggregated requests, want 200", final.Aggregated.Requests)
+	}
+}
+
+func TestErrorRateRising(t *testing.T) {
+	report, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.ErrorRateRising(0.01) {
+		t.Error("error rate going from 2.5% to 30% not detected as rising")
+	}
+	if report.ErrorRateRising(0.5) {
+		t.Error("error rate rising by less than the threshold detected as rising")
+	}
+}
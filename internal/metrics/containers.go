@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Container metrics, read from cAdvisor. They return one series per node,
+// summed over the containers of a component on the node.
+const (
+	ContainerCPU Name = "container_cpu_cores"
+	// ContainerThrottling is the ratio of CFS periods the containers were
+	// throttled in.
+	ContainerThrottling Name = "container_cpu_throttled_ratio"
+	ContainerWorkingSet Name = "container_memory_working_set_bytes"
+	ContainerRSS        Name = "container_memory_rss_bytes"
+	ContainerPageCache  Name = "container_memory_cache_bytes"
+	// ContainerNetworkReceive and ContainerNetworkTransmit are accounted to
+	// pods rather than containers. Pods in the host network namespace report
+	// the traffic of the node.
+	ContainerNetworkReceive  Name = "container_network_receive_bytes_per_second"
+	ContainerNetworkTransmit Name = "container_network_transmit_bytes_per_second"
+)
+
+// ContainerMetrics are the container metrics, in the order they are reported.
+var ContainerMetrics = []Name{
+	ContainerCPU,
+	ContainerThrottling,
+	ContainerWorkingSet,
+	ContainerRSS,
+	ContainerPageCache,
+	ContainerNetworkReceive,
+	ContainerNetworkTransmit,
+}
+
+// Containers selects the containers of a component, e.g. the Cilium agents.
+// The fields are regular expressions matching the names of the namespace, pods
+// and containers of the component, empty ones match any name.
+type Containers struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// containersSelector returns the selector of the cAdvisor series of the containers c.
+// cAdvisor reports the network metrics for the pod sandbox, whose container
+// label is "POD" or empty depending on the container runtime.
+func (m Monitoring) containersSelector(c Containers, network bool) string {
+	matchers := []string{fmt.Sprintf("%s!=%q", m.PodLabel, "")}
+	if c.Namespace != "" {
+		matchers = append(matchers, fmt.Sprintf("%s=~%q", m.NamespaceLabel, c.Namespace))
+	}
+	if c.Pod != "" {
+		matchers = append(matchers, fmt.Sprintf("%s=~%q", m.PodLabel, c.Pod))
+	}
+	if network {
+		matchers = append(matchers, fmt.Sprintf("%s=~%q", m.ContainerLabel, "POD|"))
+	} else {
+		container := c.Container
+		if container == "" {
+			container = ".+"
+		}
+		matchers = append(matchers,
+			fmt.Sprintf("%s=~%q", m.ContainerLabel, container),
+			fmt.Sprintf("%s!=%q", m.ContainerLabel, "POD"))
+	}
+	return m.Selector(matchers...)
+}
+
+// ContainerQuery returns the PromQL query of the container metric name for the
+// containers c, summed by node. Rates are computed over window.
+func (m Monitoring) ContainerQuery(name Name, c Containers, window time.Duration) (string, error) {
+	w := fmt.Sprintf("%ds", int(window.Seconds()))
+	sum := func(q string) string {
+		return fmt.Sprintf("sum by (%s) (%s)", m.NodeLabel, q)
+	}
+	rate := func(metric string, network bool) string {
+		return sum(fmt.Sprintf("rate(%s%s[%s])", metric, m.containersSelector(c, network), w))
+	}
+	gauge := func(metric string) string {
+		return sum(metric + m.containersSelector(c, false))
+	}
+
+	switch name {
+	case ContainerCPU:
+		return rate("container_cpu_usage_seconds_total", false), nil
+	case ContainerThrottling:
+		return rate("container_cpu_cfs_throttled_periods_total", false) + " / " + rate("container_cpu_cfs_periods_total", false), nil
+	case ContainerWorkingSet:
+		return gauge("container_memory_working_set_bytes"), nil
+	case ContainerRSS:
+		return gauge("container_memory_rss"), nil
+	case ContainerPageCache:
+		return gauge("container_memory_cache"), nil
+	case ContainerNetworkReceive:
+		return rate("container_network_receive_bytes_total", true), nil
+	case ContainerNetworkTransmit:
+		return rate("container_network_transmit_bytes_total", true), nil
+	}
+	return "", fmt.Errorf("metric %s is not a container metric", name)
+}
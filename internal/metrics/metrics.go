@@ -0,0 +1,240 @@
+// Package metrics maps logical metrics, e.g. the CPU used by the agents, to the
+// PromQL query returning them for a given Cilium version and monitoring setup,
+// so that the results of runs against different versions can be compared.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name is the name of a logical metric.
+type Name string
+
+// Logical metrics. Unless noted otherwise, they return one series per agent or
+// operator pod.
+const (
+	AgentCPU                Name = "agent_cpu_cores"
+	AgentResidentMemory     Name = "agent_resident_memory_bytes"
+	AgentVirtualMemory      Name = "agent_virtual_memory_bytes"
+	AgentBootstrap          Name = "agent_bootstrap_seconds"
+	AgentGoHeap             Name = "agent_go_heap_inuse_bytes"
+	AgentGoroutines         Name = "agent_goroutines"
+	AgentOpenFDs            Name = "agent_open_fds"
+	BPFMapsMemory           Name = "bpf_maps_memory_bytes"
+	BPFProgsMemory          Name = "bpf_progs_memory_bytes"
+	EndpointRegenerationP99 Name = "endpoint_regeneration_p99_seconds"
+	PolicyRegenerationP99   Name = "policy_regeneration_p99_seconds"
+	// EndpointRegenerations is the rate of endpoint regenerations, broken
+	// down by outcome.
+	EndpointRegenerations Name = "endpoint_regenerations_per_second"
+	PolicyRegenerations   Name = "policy_regenerations_per_second"
+	// Endpoints is broken down by endpoint state.
+	Endpoints Name = "endpoints"
+	// IPAddresses is broken down by address family.
+	IPAddresses            Name = "ip_addresses"
+	ServicesAdded          Name = "services_added"
+	ProxyUpstreamReplyP99  Name = "proxy_upstream_reply_p99_seconds"
+	OperatorCPU            Name = "operator_cpu_cores"
+	OperatorResidentMemory Name = "operator_resident_memory_bytes"
+	OperatorGoroutines     Name = "operator_goroutines"
+	// OperatorIdentityGCEntries is the number of identities seen by the last
+	// garbage collection of the operator, broken down by status.
+	OperatorIdentityGCEntries Name = "operator_identity_gc_entries"
+	// OperatorIdentityGCRuns is the rate of identity garbage collections,
+	// broken down by outcome.
+	OperatorIdentityGCRuns Name = "operator_identity_gc_runs_per_second"
+	// AgentContainerCPU is the CPU used by the cilium-agent container, which
+	// also runs the L7 proxy, taken from cAdvisor.
+	AgentContainerCPU Name = "agent_container_cpu_cores"
+	// AgentContainerMemory is the working set of the cilium-agent container,
+	// taken from cAdvisor.
+	AgentContainerMemory Name = "agent_container_memory_bytes"
+)
+
+// Monitoring describes the labels the monitoring setup attaches to the series
+// it scrapes.
+type Monitoring struct {
+	// Agent matches the series scraped from the Cilium agents.
+	Agent []string
+	// Operator matches the series scraped from cilium-operator.
+	Operator []string
+	// TargetPodLabel is the label holding the name of the pod the agent and
+	// operator series are scraped from.
+	TargetPodLabel string
+	// ContainerLabel, PodLabel and NamespaceLabel are the labels holding the
+	// container, pod and namespace names in the cAdvisor series.
+	ContainerLabel string
+	PodLabel       string
+	NamespaceLabel string
+	// NodeLabel is the label holding the node name in the cAdvisor series.
+	NodeLabel string
+	// Matchers are added to every selector, e.g. to only select the series of
+	// one cluster when Prometheus scrapes several.
+	Matchers []string
+}
+
+// AgentSelector returns the selector matching the series of the agents, with
+// matchers added to it.
+func (m Monitoring) AgentSelector(matchers ...string) string {
+	return m.Selector(append(append([]string(nil), m.Agent...), matchers...)...)
+}
+
+// OperatorSelector returns the selector matching the series of the operator,
+// with matchers added to it.
+func (m Monitoring) OperatorSelector(matchers ...string) string {
+	return m.Selector(append(append([]string(nil), m.Operator...), matchers...)...)
+}
+
+// ContainerSelector returns the selector matching the cAdvisor series of the
+// container name, with matchers added to it.
+func (m Monitoring) ContainerSelector(name string, matchers ...string) string {
+	return m.Selector(append([]string{fmt.Sprintf("%s=%q", m.ContainerLabel, name)}, matchers...)...)
+}
+
+// Selector returns the selector made of matchers and of the matchers of the
+// monitoring setup.
+func (m Monitoring) Selector(matchers ...string) string {
+	all := append(append([]string(nil), matchers...), m.Matchers...)
+	return "{" + strings.Join(all, ",") + "}"
+}
+
+// Definition tells how to get a logical metric.
+type Definition struct {
+	// Query returns the PromQL query of the metric, rates being computed over
+	// window.
+	Query func(m Monitoring, window string) string
+	// By is the label the metric is broken down by, if any.
+	By string
+}
+
+// Set holds the definitions of the logical metrics for a Cilium version.
+type Set map[Name]Definition
+
+// Query returns the PromQL query of the metric name, rates being computed
+// over window.
+func (s Set) Query(name Name, m Monitoring, window time.Duration) (string, error) {
+	def, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("metric %s is not available, available metrics are %v", name, s.Names())
+	}
+	return def.Query(m, fmt.Sprintf("%ds", int(window.Seconds()))), nil
+}
+
+// Aggregate returns the PromQL query aggregating the series of the metric
+// name with op, e.g. "max", across agent or operator pods.
+func (s Set) Aggregate(op string, name Name, m Monitoring, window time.Duration) (string, error) {
+	q, err := s.Query(name, m, window)
+	if err != nil {
+		return "", err
+	}
+	if by := s[name].By; by != "" {
+		return fmt.Sprintf("%s by (%s) (%s)", op, by, q), nil
+	}
+	return fmt.Sprintf("%s(%s)", op, q), nil
+}
+
+// ByPod returns the PromQL query of the metric name summed by agent or
+// operator pod, and by the label the metric is broken down by, if any.
+func (s Set) ByPod(name Name, m Monitoring, window time.Duration) (string, error) {
+	q, err := s.Query(name, m, window)
+	if err != nil {
+		return "", err
+	}
+	by := m.TargetPodLabel
+	if s[name].By != "" {
+		by += ", " + s[name].By
+	}
+	return fmt.Sprintf("sum by (%s) (%s)", by, q), nil
+}
+
+// Union returns the set of the metrics of sets, e.g. of two Cilium versions
+// naming some series differently, matching the series of all of them. The
+// queries of a metric defined by several sets are joined with "or", once each,
+// and the metric is broken down by the label of the first set defining it.
+func Union(sets ...Set) Set {
+	union := make(Set)
+	for _, set := range sets {
+		for name := range set {
+			if _, ok := union[name]; ok {
+				continue
+			}
+			var defs []Definition
+			for _, s := range sets {
+				if def, ok := s[name]; ok {
+					defs = append(defs, def)
+				}
+			}
+			union[name] = Definition{
+				Query: func(m Monitoring, window string) string {
+					var queries []string
+					seen := make(map[string]bool)
+					for _, def := range defs {
+						if q := def.Query(m, window); !seen[q] {
+							seen[q] = true
+							queries = append(queries, q)
+						}
+					}
+					if len(queries) == 1 {
+						return queries[0]
+					}
+					return "(" + strings.Join(queries, ") or (") + ")"
+				},
+				By: defs[0].By,
+			}
+		}
+	}
+	return union
+}
+
+// Names returns the metrics of the set, sorted.
+func (s Set) Names() []Name {
+	var names []Name
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Gauge returns the definition of a metric read as is from the agent gauge
+// metric.
+func Gauge(metric string, matchers ...string) Definition {
+	return Definition{Query: func(m Monitoring, _ string) string {
+		return metric + m.AgentSelector(matchers...)
+	}}
+}
+
+// Rate returns the definition of a metric computed as the per second rate of
+// the agent counter metric.
+func Rate(metric string, matchers ...string) Definition {
+	return Definition{Query: func(m Monitoring, window string) string {
+		return fmt.Sprintf("rate(%s%s[%s])", metric, m.AgentSelector(matchers...), window)
+	}}
+}
+
+// Quantile returns the definition of a metric computed as the q quantile of
+// the agent histogram metric.
+func Quantile(q float64, metric string, matchers ...string) Definition {
+	return Definition{Query: func(m Monitoring, window string) string {
+		return fmt.Sprintf("histogram_quantile(%g, rate(%s_bucket%s[%s]))", q, metric, m.AgentSelector(matchers...), window)
+	}}
+}
+
+// OperatorGauge returns the definition of a metric read as is from the
+// operator gauge metric.
+func OperatorGauge(metric string, matchers ...string) Definition {
+	return Definition{Query: func(m Monitoring, _ string) string {
+		return metric + m.OperatorSelector(matchers...)
+	}}
+}
+
+// OperatorRate returns the definition of a metric computed as the per second
+// rate of the operator counter metric.
+func OperatorRate(metric string, matchers ...string) Definition {
+	return Definition{Query: func(m Monitoring, window string) string {
+		return fmt.Sprintf("rate(%s%s[%s])", metric, m.OperatorSelector(matchers...), window)
+	}}
+}
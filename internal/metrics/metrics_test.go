@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+var monitoring = Monitoring{
+	Agent:          []string{`k8s_app="cilium"`},
+	Operator:       []string{`io_cilium_app="operator"`},
+	TargetPodLabel: "kubernetes_pod_name",
+	ContainerLabel: "container",
+	PodLabel:       "pod",
+	NamespaceLabel: "namespace",
+	NodeLabel:      "node",
+	Matchers:       []string{`test_cluster_name="perf"`},
+}
+
+func TestQuery(t *testing.T) {
+	set := Set{
+		AgentCPU: Rate("cilium_process_cpu_seconds_total"),
+		Endpoints: {
+			Query: Gauge("cilium_endpoint_state").Query,
+			By:    "endpoint_state",
+		},
+		EndpointRegenerationP99: Quantile(0.99, "cilium_endpoint_regeneration_time_stats_seconds", `scope="total"`),
+	}
+
+	tests := []struct {
+		op   string
+		name Name
+		want string
+	}{
+		{
+			op:   "max",
+			name: AgentCPU,
+			want: `max(rate(cilium_process_cpu_seconds_total{k8s_app="cilium",test_cluster_name="perf"}[60s]))`,
+		},
+		{
+			op:   "avg",
+			name: Endpoints,
+			want: `avg by (endpoint_state) (cilium_endpoint_state{k8s_app="cilium",test_cluster_name="perf"})`,
+		},
+		{
+			op:   "min",
+			name: EndpointRegenerationP99,
+			want: `min(histogram_quantile(0.99, rate(cilium_endpoint_regeneration_time_stats_seconds_bucket{k8s_app="cilium",scope="total",test_cluster_name="perf"}[60s])))`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := set.Aggregate(tt.op, tt.name, monitoring, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %s:\ngot  %s\nwant %s", tt.op, tt.name, got, tt.want)
+		}
+	}
+
+	got, err := set.ByPod(Endpoints, monitoring, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `sum by (kubernetes_pod_name, endpoint_state) (cilium_endpoint_state{k8s_app="cilium",test_cluster_name="perf"})`; got != want {
+		t.Errorf("by pod:\ngot  %s\nwant %s", got, want)
+	}
+
+	if _, err := set.Query(OperatorCPU, monitoring, time.Minute); err == nil {
+		t.Error("querying a metric missing from the set didn't fail")
+	}
+}
+
+func TestUnion(t *testing.T) {
+	v18 := Set{
+		AgentCPU: Rate("cilium_process_cpu_seconds_total"),
+		EndpointRegenerations: {
+			Query: Rate("cilium_endpoint_regenerations").Query,
+			By:    "outcome",
+		},
+	}
+	v19 := Set{
+		AgentCPU: Rate("cilium_process_cpu_seconds_total"),
+		EndpointRegenerations: {
+			Query: Rate("cilium_endpoint_regenerations_total").Query,
+			By:    "outcome",
+		},
+		OperatorCPU: OperatorRate("cilium_operator_process_cpu_seconds_total"),
+	}
+	union := Union(v18, v19)
+
+	tests := map[Name]string{
+		AgentCPU:              `sum(rate(cilium_process_cpu_seconds_total{k8s_app="cilium",test_cluster_name="perf"}[60s]))`,
+		EndpointRegenerations: `sum by (outcome) ((rate(cilium_endpoint_regenerations{k8s_app="cilium",test_cluster_name="perf"}[60s])) or (rate(cilium_endpoint_regenerations_total{k8s_app="cilium",test_cluster_name="perf"}[60s])))`,
+		OperatorCPU:           `sum(rate(cilium_operator_process_cpu_seconds_total{io_cilium_app="operator",test_cluster_name="perf"}[60s]))`,
+	}
+	for name, want := range tests {
+		got, err := union.Aggregate("sum", name, monitoring, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s:\ngot  %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	if got, want := monitoring.ContainerSelector("cilium-agent"), `{container="cilium-agent",test_cluster_name="perf"}`; got != want {
+		t.Errorf("got container selector %s, want %s", got, want)
+	}
+	if got, want := monitoring.OperatorSelector(), `{io_cilium_app="operator",test_cluster_name="perf"}`; got != want {
+		t.Errorf("got operator selector %s, want %s", got, want)
+	}
+}
+
+func TestContainerQuery(t *testing.T) {
+	operator := Containers{Pod: "cilium-operator-.*", Container: "cilium-operator"}
+	workload := Containers{Namespace: "test-abc"}
+
+	tests := []struct {
+		name       Name
+		containers Containers
+		want       string
+	}{
+		{
+			name:       ContainerThrottling,
+			containers: operator,
+			want: `sum by (node) (rate(container_cpu_cfs_throttled_periods_total{pod!="",pod=~"cilium-operator-.*",container=~"cilium-operator",container!="POD",test_cluster_name="perf"}[60s]))` +
+				` / sum by (node) (rate(container_cpu_cfs_periods_total{pod!="",pod=~"cilium-operator-.*",container=~"cilium-operator",container!="POD",test_cluster_name="perf"}[60s]))`,
+		},
+		{
+			name:       ContainerWorkingSet,
+			containers: workload,
+			want:       `sum by (node) (container_memory_working_set_bytes{pod!="",namespace=~"test-abc",container=~".+",container!="POD",test_cluster_name="perf"})`,
+		},
+		{
+			name:       ContainerNetworkReceive,
+			containers: workload,
+			want:       `sum by (node) (rate(container_network_receive_bytes_total{pod!="",namespace=~"test-abc",container=~"POD|",test_cluster_name="perf"}[60s]))`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := monitoring.ContainerQuery(tt.name, tt.containers, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, name := range ContainerMetrics {
+		if _, err := monitoring.ContainerQuery(name, workload, time.Minute); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := monitoring.ContainerQuery(AgentCPU, workload, time.Minute); err == nil {
+		t.Error("querying an agent metric as a container metric didn't fail")
+	}
+}
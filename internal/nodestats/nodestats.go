@@ -0,0 +1,268 @@
+// Package nodestats reads the CPU, softirq and network counters of a node from
+// procfs and computes the node activity between two readings.
+//
+// Most of the datapath CPU is spent in softirq context, which isn't accounted
+// to any process. The kernel only exposes the time spent in softirqs for all
+// softirq types together, in /proc/stat, and the number of softirqs handled by
+// type, in /proc/softirqs, so the time is reported for all types and the
+// NET_RX and NET_TX activity as counts.
+package nodestats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command prints the procfs files Parse reads. It must be run in the host
+// network namespace for the network counters to be the ones of the node.
+var Command = []string{"sh", "-c", `for f in stat softirqs net/dev net/snmp; do echo "==> /proc/$f"; cat /proc/$f; done`}
+
+// userHZ is the unit of the CPU times of /proc/stat.
+const userHZ = 100
+
+// cpuModes are the columns of the cpu lines of /proc/stat.
+var cpuModes = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
+
+// Snapshot holds the counters of a node at a point in time.
+type Snapshot struct {
+	Time time.Time
+	// CPU is the time spent by all the CPUs, by mode, e.g. "softirq".
+	CPU map[string]time.Duration
+	// Softirqs is the number of softirqs handled by all the CPUs, by type,
+	// e.g. "NET_RX".
+	Softirqs   map[string]uint64
+	Interfaces map[string]Interface
+	TCP        TCP
+}
+
+// Interface holds the counters of a network interface.
+type Interface struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxDrops   uint64 `json:"rx_drops"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxDrops   uint64 `json:"tx_drops"`
+}
+
+// TCP holds the TCP counters of the node.
+type TCP struct {
+	OutSegs     uint64 `json:"out_segs"`
+	RetransSegs uint64 `json:"retrans_segs"`
+}
+
+// Parse reads a snapshot taken at time at from the output of Command.
+func Parse(r io.Reader, at time.Time) (*Snapshot, error) {
+	s := &Snapshot{
+		Time:       at,
+		CPU:        make(map[string]time.Duration),
+		Softirqs:   make(map[string]uint64),
+		Interfaces: make(map[string]Interface),
+	}
+
+	sections := make(map[string][]string)
+	var file string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "==> ") {
+			file = strings.TrimPrefix(line, "==> ")
+			continue
+		}
+		sections[file] = append(sections[file], line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range []struct {
+		file  string
+		parse func(s *Snapshot, lines []string) error
+	}{
+		{"/proc/stat", parseStat},
+		{"/proc/softirqs", parseSoftirqs},
+		{"/proc/net/dev", parseNetDev},
+		{"/proc/net/snmp", parseSNMP},
+	} {
+		lines, ok := sections[p.file]
+		if !ok {
+			return nil, fmt.Errorf("%s missing", p.file)
+		}
+		if err := p.parse(s, lines); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", p.file, err)
+		}
+	}
+	return s, nil
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parseStat(s *Snapshot, lines []string) error {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		values, err := parseUints(fields[1:])
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			if i < len(cpuModes) {
+				s.CPU[cpuModes[i]] = time.Duration(v) * time.Second / userHZ
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cpu line not found")
+}
+
+func parseSoftirqs(s *Snapshot, lines []string) error {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			// CPU header.
+			continue
+		}
+		values, err := parseUints(fields[1:])
+		if err != nil {
+			return err
+		}
+		var total uint64
+		for _, v := range values {
+			total += v
+		}
+		s.Softirqs[strings.TrimSuffix(fields[0], ":")] = total
+	}
+	return nil
+}
+
+func parseNetDev(s *Snapshot, lines []string) error {
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 || strings.Contains(line, "|") {
+			// Headers.
+			continue
+		}
+		name := strings.TrimSpace(line[:colon])
+		values, err := parseUints(strings.Fields(line[colon+1:]))
+		if err != nil {
+			return err
+		}
+		if len(values) < 12 {
+			return fmt.Errorf("unexpected counters for %s: %q", name, line)
+		}
+		s.Interfaces[name] = Interface{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxDrops:   values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxDrops:   values[11],
+		}
+	}
+	return nil
+}
+
+func parseSNMP(s *Snapshot, lines []string) error {
+	// Each protocol has a line with the counter names followed by one with
+	// their values.
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "Tcp:") || !strings.HasPrefix(lines[i+1], "Tcp:") {
+			continue
+		}
+		names := strings.Fields(lines[i])[1:]
+		values := strings.Fields(lines[i+1])[1:]
+		if len(names) != len(values) {
+			return fmt.Errorf("unexpected Tcp counters %q", lines[i+1])
+		}
+		for j, name := range names {
+			var dst *uint64
+			switch name {
+			case "OutSegs":
+				dst = &s.TCP.OutSegs
+			case "RetransSegs":
+				dst = &s.TCP.RetransSegs
+			default:
+				continue
+			}
+			v, err := strconv.ParseUint(values[j], 10, 64)
+			if err != nil {
+				return err
+			}
+			*dst = v
+		}
+		return nil
+	}
+	return fmt.Errorf("Tcp counters not found")
+}
+
+// Usage is the activity of a node between two snapshots.
+type Usage struct {
+	Window time.Duration `json:"window"`
+	// CPUCores is the average number of CPUs busy in each mode, e.g. "softirq".
+	CPUCores map[string]float64 `json:"cpu_cores"`
+	// Softirqs is the number of softirqs handled, by type.
+	Softirqs map[string]uint64 `json:"softirqs"`
+	// Interfaces holds the traffic of the network interfaces, by name.
+	Interfaces map[string]Interface `json:"interfaces"`
+	TCP        TCP                  `json:"tcp"`
+}
+
+// delta returns after - before, or 0 if the counter went backwards.
+func delta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+// Diff returns the activity of the node between the snapshots before and
+// after. Interfaces created in between are counted from zero, the ones removed
+// in between are left out.
+func Diff(before, after *Snapshot) Usage {
+	u := Usage{
+		Window:     after.Time.Sub(before.Time),
+		CPUCores:   make(map[string]float64),
+		Softirqs:   make(map[string]uint64),
+		Interfaces: make(map[string]Interface),
+		TCP: TCP{
+			OutSegs:     delta(before.TCP.OutSegs, after.TCP.OutSegs),
+			RetransSegs: delta(before.TCP.RetransSegs, after.TCP.RetransSegs),
+		},
+	}
+	for mode, t := range after.CPU {
+		if u.Window > 0 && t >= before.CPU[mode] {
+			u.CPUCores[mode] = float64(t-before.CPU[mode]) / float64(u.Window)
+		}
+	}
+	for typ, n := range after.Softirqs {
+		u.Softirqs[typ] = delta(before.Softirqs[typ], n)
+	}
+	for name, a := range after.Interfaces {
+		b := before.Interfaces[name]
+		u.Interfaces[name] = Interface{
+			RxBytes:   delta(b.RxBytes, a.RxBytes),
+			RxPackets: delta(b.RxPackets, a.RxPackets),
+			RxDrops:   delta(b.RxDrops, a.RxDrops),
+			TxBytes:   delta(b.TxBytes, a.TxBytes),
+			TxPackets: delta(b.TxPackets, a.TxPackets),
+			TxDrops:   delta(b.TxDrops, a.TxDrops),
+		}
+	}
+	return u
+}
@@ -0,0 +1,92 @@
+package nodestats
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const snapshot = `==> /proc/stat
+cpu  %d 20 300 4000 5 6 %d 8 0 0
+cpu0 500 10 150 2000 3 3 100 4 0 0
+cpu1 500 10 150 2000 2 3 100 4 0 0
+intr 123456 0 9 0
+ctxt 987654
+btime 1600000000
+==> /proc/softirqs
+                    CPU0       CPU1
+          HI:          1          0
+       TIMER:      10000      12000
+      NET_TX:         %d         10
+      NET_RX:       5000       6000
+==> /proc/net/dev
+Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0:%d 2000 0 3 0 0 0 0 400000 1500 0 1 0 0 0 0
+==> /proc/net/snmp
+Ip: Forwarding DefaultTTL
+Ip: 1 64
+Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens PassiveOpens AttemptFails EstabResets CurrEstab InSegs OutSegs RetransSegs InErrs OutRsts InCsumErrors
+Tcp: 1 200 120000 -1 100 50 0 0 10 10000 %d %d 0 0 0
+`
+
+func parse(t *testing.T, user, softirq, netTX, rxBytes, outSegs, retrans int, at time.Time) *Snapshot {
+	s, err := Parse(strings.NewReader(fmt.Sprintf(snapshot, user, softirq, netTX, rxBytes, outSegs, retrans)), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	at := time.Unix(1600000000, 0)
+	s := parse(t, 1000, 200, 40, 3000000, 20000, 12, at)
+
+	if s.CPU["user"] != 10*time.Second || s.CPU["softirq"] != 2*time.Second || s.CPU["guest_nice"] != 0 {
+		t.Errorf("unexpected CPU times %v", s.CPU)
+	}
+	if s.Softirqs["NET_RX"] != 11000 || s.Softirqs["NET_TX"] != 50 {
+		t.Errorf("unexpected softirqs %v", s.Softirqs)
+	}
+	want := Interface{RxBytes: 3000000, RxPackets: 2000, RxDrops: 3, TxBytes: 400000, TxPackets: 1500, TxDrops: 1}
+	if got := s.Interfaces["eth0"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got eth0 counters %+v, want %+v", got, want)
+	}
+	if s.TCP != (TCP{OutSegs: 20000, RetransSegs: 12}) {
+		t.Errorf("unexpected TCP counters %+v", s.TCP)
+	}
+}
+
+func TestParseMissingFile(t *testing.T) {
+	if _, err := Parse(strings.NewReader("==> /proc/stat\ncpu 1 2 3 4\n"), time.Now()); err == nil {
+		t.Error("no error for missing files")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	before := parse(t, 1000, 200, 40, 3000000, 20000, 12, start)
+	after := parse(t, 3000, 1200, 90, 3500000, 30000, 32, start.Add(10*time.Second))
+	after.Interfaces["lxc1234"] = Interface{RxBytes: 10}
+
+	u := Diff(before, after)
+	if u.Window != 10*time.Second {
+		t.Errorf("got window %v", u.Window)
+	}
+	if math.Abs(u.CPUCores["softirq"]-1) > 1e-9 || math.Abs(u.CPUCores["user"]-2) > 1e-9 {
+		t.Errorf("unexpected CPU usage %v", u.CPUCores)
+	}
+	if u.Softirqs["NET_TX"] != 50 || u.Softirqs["NET_RX"] != 0 {
+		t.Errorf("unexpected softirqs %v", u.Softirqs)
+	}
+	if u.Interfaces["eth0"].RxBytes != 500000 || u.Interfaces["lxc1234"].RxBytes != 10 {
+		t.Errorf("unexpected interface traffic %v", u.Interfaces)
+	}
+	if u.TCP != (TCP{OutSegs: 10000, RetransSegs: 20}) {
+		t.Errorf("unexpected TCP counters %+v", u.TCP)
+	}
+}
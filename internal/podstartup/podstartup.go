@@ -0,0 +1,117 @@
+// Package podstartup computes the latencies of the phases of pod startup from
+// the times at which the pods reached each step, with the time spent pulling
+// images taken out so that what remains is dominated by the scheduler, the
+// container runtime and the CNI.
+package podstartup
+
+import (
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/latency"
+)
+
+// Pod holds the time at which a pod reached each step of its startup.
+//
+// Kubernetes doesn't record when a pod sandbox is created, so the first kubelet
+// event about the pod containers (pulling an image, creating or starting a
+// container) is used instead: the kubelet only gets to the containers once the
+// sandbox, and hence the pod network set up by the CNI, is in place. Events
+// have a one second resolution.
+//
+// Pod status doesn't record when the pod IP was assigned either, IPAssigned is
+// the time at which the pod was first seen with an IP.
+type Pod struct {
+	Name              string
+	Created           time.Time
+	Scheduled         time.Time
+	SandboxCreated    time.Time
+	IPAssigned        time.Time
+	ContainersStarted time.Time
+	Ready             time.Time
+
+	// Pulls holds the image pulls, by container.
+	Pulls map[string]*Pull
+}
+
+// Pull is the start and end time of an image pull.
+type Pull struct {
+	Start, End time.Time
+}
+
+// PullTime returns the time spent pulling images.
+func (p *Pod) PullTime() time.Duration {
+	var total time.Duration
+	for _, pull := range p.Pulls {
+		if !pull.Start.IsZero() && pull.End.After(pull.Start) {
+			total += pull.End.Sub(pull.Start)
+		}
+	}
+	return total
+}
+
+// Phase is a phase of pod startup.
+type Phase struct {
+	Name string
+	From func(p *Pod) time.Time
+	To   func(p *Pod) time.Time
+	// ExcludePulls removes the time spent pulling images from the latency.
+	ExcludePulls bool
+}
+
+// Phases are the phases pod startup latencies are reported for.
+var Phases = []Phase{
+	{
+		Name: "scheduled",
+		From: func(p *Pod) time.Time { return p.Created },
+		To:   func(p *Pod) time.Time { return p.Scheduled },
+	},
+	{
+		Name: "sandbox_created",
+		From: func(p *Pod) time.Time { return p.Scheduled },
+		To:   func(p *Pod) time.Time { return p.SandboxCreated },
+	},
+	{
+		Name: "ip_assigned",
+		From: func(p *Pod) time.Time { return p.Scheduled },
+		To:   func(p *Pod) time.Time { return p.IPAssigned },
+	},
+	{
+		Name:         "containers_started",
+		From:         func(p *Pod) time.Time { return p.SandboxCreated },
+		To:           func(p *Pod) time.Time { return p.ContainersStarted },
+		ExcludePulls: true,
+	},
+	{
+		Name: "ready",
+		From: func(p *Pod) time.Time { return p.ContainersStarted },
+		To:   func(p *Pod) time.Time { return p.Ready },
+	},
+	{
+		Name:         "total",
+		From:         func(p *Pod) time.Time { return p.Created },
+		To:           func(p *Pod) time.Time { return p.Ready },
+		ExcludePulls: true,
+	},
+}
+
+// Latencies returns the sorted latencies of phase for the pods that made it
+// through it.
+func (phase Phase) Latencies(pods []*Pod) []time.Duration {
+	var latencies []time.Duration
+	for _, p := range pods {
+		from, to := phase.From(p), phase.To(p)
+		if from.IsZero() || to.IsZero() {
+			continue
+		}
+		elapsed := to.Sub(from)
+		if phase.ExcludePulls {
+			elapsed -= p.PullTime()
+		}
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		latencies = append(latencies, elapsed)
+	}
+	latency.Sort(latencies)
+	return latencies
+}
@@ -0,0 +1,52 @@
+package podstartup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLatencies(t *testing.T) {
+	base := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	pods := []*Pod{
+		{
+			Name:              "pulled",
+			Created:           at(0),
+			Scheduled:         at(1),
+			SandboxCreated:    at(3),
+			ContainersStarted: at(15),
+			Ready:             at(16),
+			Pulls: map[string]*Pull{
+				"spec.containers{a}": {Start: at(3), End: at(13)},
+				// Image already present, no pull time.
+				"spec.containers{b}": {End: at(13)},
+			},
+		},
+		{
+			Name:              "not ready",
+			Created:           at(0),
+			Scheduled:         at(2),
+			SandboxCreated:    at(4),
+			ContainersStarted: at(5),
+		},
+	}
+	phases := make(map[string]Phase)
+	for _, phase := range Phases {
+		phases[phase.Name] = phase
+	}
+
+	tests := map[string][]time.Duration{
+		"scheduled":          {time.Second, 2 * time.Second},
+		"sandbox_created":    {2 * time.Second, 2 * time.Second},
+		"containers_started": {time.Second, 2 * time.Second},
+		"ready":              {time.Second},
+		"total":              {6 * time.Second},
+		"ip_assigned":        nil,
+	}
+	for name, want := range tests {
+		if got := phases[name].Latencies(pods); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s latencies = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package profile compares the pprof profiles collected from the Cilium agents
+// during two runs.
+package profile
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Load reads the profiles of the given kind, e.g. "cpu" or "heap", found under
+// dir and merges them into one.
+func Load(dir, kind string) (*profile.Profile, error) {
+	var profiles []*profile.Profile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasPrefix(info.Name(), kind+"-") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		p, err := profile.Parse(f)
+		if err != nil {
+			return fmt.Errorf("failed to parse profile %s: %w", path, err)
+		}
+		profiles = append(profiles, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no %s profile found in %s", kind, dir)
+	}
+
+	return profile.Merge(profiles)
+}
+
+// Top returns the flat value of each function of p, i.e. the value of the
+// samples the function is the leaf of, for the default sample type of p.
+func Top(p *profile.Profile) map[string]int64 {
+	index := len(p.SampleType) - 1
+	for i, st := range p.SampleType {
+		if st.Type == p.DefaultSampleType {
+			index = i
+		}
+	}
+
+	top := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 {
+			continue
+		}
+		// The first line of the first location is the innermost inlined
+		// function the sample was taken in.
+		if fn := s.Location[0].Line[0].Function; fn != nil {
+			top[fn.Name] += s.Value[index]
+		}
+	}
+	return top
+}
+
+// Delta is the change of the flat value of a function between two runs.
+type Delta struct {
+	Function string
+	Base     int64
+	Current  int64
+}
+
+// Diff returns the change of value of the functions of base and current, the
+// largest changes first.
+func Diff(base, current map[string]int64) []Delta {
+	var deltas []Delta
+	for fn, v := range base {
+		deltas = append(deltas, Delta{Function: fn, Base: v, Current: current[fn]})
+	}
+	for fn, v := range current {
+		if _, ok := base[fn]; !ok {
+			deltas = append(deltas, Delta{Function: fn, Current: v})
+		}
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := math.Abs(float64(deltas[i].Current-deltas[i].Base)), math.Abs(float64(deltas[j].Current-deltas[j].Base))
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].Function < deltas[j].Function
+	})
+	return deltas
+}
@@ -0,0 +1,94 @@
+package profile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func newProfile(values map[string]int64) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		DefaultSampleType: "cpu",
+		PeriodType:        &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+	}
+	id := uint64(1)
+	for name, v := range values {
+		fn := &profile.Function{ID: id, Name: name}
+		loc := &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{1, v},
+		})
+		id++
+	}
+	return p
+}
+
+func writeProfile(t *testing.T, path string, p *profile.Profile) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProfile(t, filepath.Join(dir, "node-1", "cpu-1.pb.gz"), newProfile(map[string]int64{"a": 10, "b": 5}))
+	writeProfile(t, filepath.Join(dir, "node-2", "cpu-1.pb.gz"), newProfile(map[string]int64{"a": 20}))
+	writeProfile(t, filepath.Join(dir, "node-2", "heap-1.pb.gz"), newProfile(map[string]int64{"c": 100}))
+
+	p, err := Load(dir, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := Top(p)
+	if top["a"] != 30 || top["b"] != 5 || len(top) != 2 {
+		t.Errorf("unexpected top functions %v", top)
+	}
+
+	if _, err := Load(dir, "goroutine"); err == nil {
+		t.Error("loading missing profiles didn't fail")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	deltas := Diff(
+		map[string]int64{"a": 10, "b": 50, "c": 5},
+		map[string]int64{"a": 100, "b": 40, "d": 20},
+	)
+	want := []Delta{
+		{Function: "a", Base: 10, Current: 100},
+		{Function: "d", Current: 20},
+		{Function: "b", Base: 50, Current: 40},
+		{Function: "c", Base: 5},
+	}
+	if len(deltas) != len(want) {
+		t.Fatalf("got %d deltas, want %d", len(deltas), len(want))
+	}
+	for i := range want {
+		if deltas[i] != want[i] {
+			t.Errorf("delta %d: got %+v, want %+v", i, deltas[i], want[i])
+		}
+	}
+}
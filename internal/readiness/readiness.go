@@ -0,0 +1,201 @@
+// Package readiness tells when the workloads created from manifests, e.g.
+// DaemonSets or Deployments, are ready from the status of the objects
+// themselves rather than from an expected number of pods.
+package readiness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/yamldoc"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Kinds of the workloads whose readiness is known.
+const (
+	DaemonSet   = "DaemonSet"
+	Deployment  = "Deployment"
+	Job         = "Job"
+	StatefulSet = "StatefulSet"
+)
+
+// Object identifies a workload.
+type Object struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (o Object) String() string {
+	return fmt.Sprintf("%s %s/%s", strings.ToLower(o.Kind), o.Namespace, o.Name)
+}
+
+// Workload returns the workload obj is, if any. Objects without a namespace
+// are given namespace.
+func Workload(obj runtime.Object, namespace string) (Object, bool) {
+	var kind string
+	switch obj.(type) {
+	case *appsv1.DaemonSet:
+		kind = DaemonSet
+	case *appsv1.Deployment:
+		kind = Deployment
+	case *batchv1.Job:
+		kind = Job
+	case *appsv1.StatefulSet:
+		kind = StatefulSet
+	default:
+		return Object{}, false
+	}
+	m := obj.(metav1.Object)
+	if m.GetNamespace() != "" {
+		namespace = m.GetNamespace()
+	}
+	return Object{Kind: kind, Namespace: namespace, Name: m.GetName()}, true
+}
+
+// Manifest returns the workloads defined by the YAML manifest, objects without
+// a namespace being given namespace. Objects of kinds not known to client-go,
+// e.g. Cilium custom resources, are skipped.
+func Manifest(manifest io.Reader, namespace string) ([]Object, error) {
+	docs, err := yamldoc.Read(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, doc := range docs {
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+		if runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if o, ok := Workload(obj, namespace); ok {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+// Check tells whether the workload obj is ready and describes its progress.
+// It returns an error if obj will never become ready, e.g. a failed Job.
+func Check(obj runtime.Object) (bool, string, error) {
+	switch o := obj.(type) {
+	case *appsv1.DaemonSet:
+		desired := o.Status.DesiredNumberScheduled
+		status := fmt.Sprintf("%d/%d pods ready, %d updated", o.Status.NumberReady, desired, o.Status.UpdatedNumberScheduled)
+		return o.Status.ObservedGeneration >= o.Generation &&
+			o.Status.UpdatedNumberScheduled == desired &&
+			o.Status.NumberReady == desired, status, nil
+
+	case *appsv1.Deployment:
+		replicas := replicas(o.Spec.Replicas)
+		status := fmt.Sprintf("%d/%d replicas available, %d updated, %d total",
+			o.Status.AvailableReplicas, replicas, o.Status.UpdatedReplicas, o.Status.Replicas)
+		for _, c := range o.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse {
+				return false, status, fmt.Errorf("deployment not progressing: %s", c.Message)
+			}
+		}
+		return o.Status.ObservedGeneration >= o.Generation &&
+			o.Status.UpdatedReplicas == replicas &&
+			o.Status.AvailableReplicas == replicas &&
+			o.Status.Replicas == replicas, status, nil
+
+	case *batchv1.Job:
+		completions := replicas(o.Spec.Completions)
+		status := fmt.Sprintf("%d/%d completions, %d active, %d failed",
+			o.Status.Succeeded, completions, o.Status.Active, o.Status.Failed)
+		for _, c := range o.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return false, status, fmt.Errorf("job failed: %s", c.Message)
+			}
+		}
+		return o.Status.Succeeded >= completions, status, nil
+
+	case *appsv1.StatefulSet:
+		replicas := replicas(o.Spec.Replicas)
+		status := fmt.Sprintf("%d/%d replicas ready, %d updated", o.Status.ReadyReplicas, replicas, o.Status.UpdatedReplicas)
+		return o.Status.ObservedGeneration >= o.Generation &&
+			o.Status.UpdatedReplicas == replicas &&
+			o.Status.ReadyReplicas == replicas, status, nil
+	}
+	return false, "", fmt.Errorf("readiness of %T is unknown", obj)
+}
+
+// replicas returns the replica count r, which defaults to 1 when unset.
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+// get returns the current state of the workload o.
+func get(client kubernetes.Interface, o Object) (runtime.Object, error) {
+	ctx := context.TODO()
+	switch o.Kind {
+	case DaemonSet:
+		return client.AppsV1().DaemonSets(o.Namespace).Get(ctx, o.Name, metav1.GetOptions{})
+	case Deployment:
+		return client.AppsV1().Deployments(o.Namespace).Get(ctx, o.Name, metav1.GetOptions{})
+	case Job:
+		return client.BatchV1().Jobs(o.Namespace).Get(ctx, o.Name, metav1.GetOptions{})
+	case StatefulSet:
+		return client.AppsV1().StatefulSets(o.Namespace).Get(ctx, o.Name, metav1.GetOptions{})
+	}
+	return nil, fmt.Errorf("readiness of kind %s is unknown", o.Kind)
+}
+
+// PollInterval is how often Wait checks the workloads.
+var PollInterval = 2 * time.Second
+
+// Wait waits for the workloads objects to be ready. When they are not ready
+// within timeout, the returned error lists the ones that are stuck and their
+// progress.
+func Wait(client kubernetes.Interface, objects []Object, timeout time.Duration) error {
+	pending := append([]Object(nil), objects...)
+	status := make(map[Object]string)
+
+	err := wait.Poll(PollInterval, timeout, func() (bool, error) {
+		var notReady []Object
+		for _, o := range pending {
+			obj, err := get(client, o)
+			if err != nil {
+				// The object may not be visible yet, try again.
+				status[o] = err.Error()
+				notReady = append(notReady, o)
+				continue
+			}
+			ready, s, err := Check(obj)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", o, err)
+			}
+			status[o] = s
+			if !ready {
+				notReady = append(notReady, o)
+			}
+		}
+		pending = notReady
+		return len(pending) == 0, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		var stuck []string
+		for _, o := range pending {
+			stuck = append(stuck, fmt.Sprintf("%s (%s)", o, status[o]))
+		}
+		return fmt.Errorf("timed out after %v waiting for %s", timeout, strings.Join(stuck, ", "))
+	}
+	return err
+}
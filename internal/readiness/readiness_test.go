@@ -0,0 +1,177 @@
+package readiness
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		obj     runtime.Object
+		ready   bool
+		wantErr bool
+	}{
+		{
+			name: "daemonset not observed yet",
+			obj:  &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Generation: 1}},
+		},
+		{
+			name: "daemonset rolling out",
+			obj: &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 2},
+			},
+		},
+		{
+			name: "daemonset ready",
+			obj: &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberReady: 3},
+			},
+			ready: true,
+		},
+		{
+			name: "deployment scaling down",
+			obj: &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: int32Ptr(1)},
+				Status: appsv1.DeploymentStatus{Replicas: 5, UpdatedReplicas: 1, AvailableReplicas: 1},
+			},
+		},
+		{
+			name: "deployment with default replicas ready",
+			obj: &appsv1.Deployment{
+				Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+			},
+			ready: true,
+		},
+		{
+			name: "deployment past its progress deadline",
+			obj: &appsv1.Deployment{
+				Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Message: "ProgressDeadlineExceeded"},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "job running",
+			obj: &batchv1.Job{
+				Spec:   batchv1.JobSpec{Completions: int32Ptr(2)},
+				Status: batchv1.JobStatus{Succeeded: 1, Active: 1},
+			},
+		},
+		{
+			name:  "job complete",
+			obj:   &batchv1.Job{Status: batchv1.JobStatus{Succeeded: 1}},
+			ready: true,
+		},
+		{
+			name: "job failed",
+			obj: &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "statefulset ready",
+			obj: &appsv1.StatefulSet{
+				Spec:   appsv1.StatefulSetSpec{Replicas: int32Ptr(2)},
+				Status: appsv1.StatefulSetStatus{UpdatedReplicas: 2, ReadyReplicas: 2},
+			},
+			ready: true,
+		},
+		{
+			name:    "unknown kind",
+			obj:     &corev1.Service{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		ready, status, err := Check(tt.obj)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if ready != tt.ready {
+			t.Errorf("%s: got ready %v (%s), want %v", tt.name, ready, status, tt.ready)
+		}
+	}
+}
+
+const manifest = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cilium
+  namespace: kube-system
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: cilium
+  namespace: kube-system
+---
+apiVersion: cilium.io/v2
+kind: CiliumNetworkPolicy
+metadata:
+  name: l7
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: prometheus
+`
+
+func TestManifest(t *testing.T) {
+	objects, err := Manifest(strings.NewReader(manifest), "cilium-monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Object{
+		{Kind: DaemonSet, Namespace: "kube-system", Name: "cilium"},
+		{Kind: Deployment, Namespace: "cilium-monitoring", Name: "prometheus"},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("got %v, want %v", objects, want)
+	}
+}
+
+func TestWait(t *testing.T) {
+	PollInterval = 10 * time.Millisecond
+
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ready"},
+			Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stuck"},
+			Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+			Status:     appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 1},
+		},
+	)
+
+	ready := Object{Kind: Deployment, Namespace: "default", Name: "ready"}
+	if err := Wait(client, []Object{ready}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	stuck := Object{Kind: Deployment, Namespace: "default", Name: "stuck"}
+	err := Wait(client, []Object{ready, stuck}, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("no error waiting for a stuck deployment")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "deployment default/stuck (1/3 replicas available") || strings.Contains(msg, "default/ready") {
+		t.Errorf("error does not name the stuck object only: %s", msg)
+	}
+}
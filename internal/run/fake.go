@@ -0,0 +1,52 @@
+package run
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeResult is the outcome of a command run by Fake.
+type FakeResult struct {
+	Result
+	// ExitCode makes Run return an ExitError if not zero.
+	ExitCode int
+	// Err is returned by Run if set.
+	Err error
+}
+
+// Fake is a Runner recording the commands instead of running them, for tests.
+type Fake struct {
+	mu sync.Mutex
+	// Results holds the outcome of the commands, by command line, e.g.
+	// "minikube status". Commands not found in Results succeed with no output.
+	Results map[string]FakeResult
+	calls   []Cmd
+}
+
+// Run implements Runner.
+func (f *Fake) Run(ctx context.Context, cmd Cmd) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, cmd)
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	r := f.Results[cmd.String()]
+	switch {
+	case r.Err != nil:
+		return r.Result, r.Err
+	case r.ExitCode != 0:
+		return r.Result, &ExitError{Cmd: cmd, ExitCode: r.ExitCode, Stderr: r.Stderr}
+	}
+	return r.Result, nil
+}
+
+// Calls returns the commands run so far.
+func (f *Fake) Calls() []Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Cmd(nil), f.calls...)
+}
@@ -1,47 +1,124 @@
+// Package run runs the external commands, e.g. kubectl, minikube or gcloud,
+// the perf tests rely on.
 package run
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
-	"sync"
+	"time"
 )
 
-// Command ...
-func Command(name string, args ...string) error {
-	full := fmt.Sprintf("%s %s", name, strings.Join(args, " "))
+// Cmd is a command to run.
+type Cmd struct {
+	Name string
+	Args []string
+	// Env holds environment variables, as "key=value", set in addition to the
+	// ones of the current process.
+	Env []string
+	// Dir is the working directory of the command, the current one if empty.
+	Dir   string
+	Stdin io.Reader
+	// Tee copies the output of the command to the standard output and error
+	// of the current process as it is produced, in addition to capturing it.
+	Tee bool
+}
+
+func (c Cmd) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// Result is the output of a command.
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// ExitError is returned when a command exits with a non-zero status.
+type ExitError struct {
+	Cmd      Cmd
+	ExitCode int
+	Stderr   []byte
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("command %q exited with status %d", e.Cmd, e.ExitCode)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
 
-	log.Printf("Running %q\n", full)
-	cmd := exec.Command(name, args...)
+// Runner runs commands. The command is killed if ctx is done before it exits.
+type Runner interface {
+	Run(ctx context.Context, cmd Cmd) (Result, error)
+}
+
+// Exec runs commands as child processes.
+type Exec struct {
+	// Logf logs the commands that are run and their outcome, log.Printf is
+	// used if nil.
+	Logf func(format string, args ...interface{})
+}
 
-	var wg sync.WaitGroup
+// Default is the Runner used by Command.
+var Default Runner = &Exec{}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %v", err)
+// Run implements Runner.
+func (e *Exec) Run(ctx context.Context, cmd Cmd) (Result, error) {
+	logf := e.Logf
+	if logf == nil {
+		logf = log.Printf
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %v", err)
+
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Stdin = cmd.Stdin
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
 	}
 
-	wg.Add(2)
-	go func() {
-		io.Copy(os.Stdout, stdout)
-		wg.Done()
-	}()
-	go func() {
-		io.Copy(os.Stderr, stderr)
-		wg.Done()
-	}()
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error while runnning command %q: %v", full, err)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if cmd.Tee {
+		c.Stdout = io.MultiWriter(&stdout, os.Stdout)
+		c.Stderr = io.MultiWriter(&stderr, os.Stderr)
 	}
 
-	wg.Wait()
-	return nil
+	logf("Running %q", cmd)
+	start := time.Now()
+	err := c.Run()
+	result := Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		err = fmt.Errorf("command %q interrupted: %w", cmd, ctx.Err())
+	case errors.As(err, &exitErr):
+		err = &ExitError{Cmd: cmd, ExitCode: exitErr.ExitCode(), Stderr: result.Stderr}
+	case err != nil:
+		err = fmt.Errorf("failed to run command %q: %w", cmd, err)
+	}
+	logf("command=%q dir=%q duration=%v error=%v", cmd, cmd.Dir, time.Since(start).Round(time.Millisecond), err)
+	return result, err
+}
+
+// Command runs the command name with args using the Default runner, copying
+// its output to the standard output and error.
+func Command(name string, args ...string) error {
+	_, err := Default.Run(context.Background(), Cmd{Name: name, Args: args, Tee: true})
+	return err
+}
+
+// Output runs the command name with args using the Default runner and returns
+// its standard output.
+func Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	result, err := Default.Run(ctx, Cmd{Name: name, Args: args})
+	return result.Stdout, err
 }
@@ -0,0 +1,103 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nopLogf(string, ...interface{}) {}
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &Exec{Logf: nopLogf}
+	result, err := r.Run(context.Background(), Cmd{
+		Name: "sh",
+		Args: []string{"-c", `echo "$GREETING"; pwd; echo oops >&2`},
+		Env:  []string{"GREETING=hello"},
+		Dir:  dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(result.Stdout)), "\n")
+	if len(lines) != 2 || lines[0] != "hello" || lines[1] != wd {
+		t.Errorf("unexpected stdout %q", result.Stdout)
+	}
+	if string(result.Stderr) != "oops\n" {
+		t.Errorf("unexpected stderr %q", result.Stderr)
+	}
+}
+
+func TestExecExitError(t *testing.T) {
+	r := &Exec{Logf: nopLogf}
+	result, err := r.Run(context.Background(), Cmd{Name: "sh", Args: []string{"-c", "echo out; echo failed >&2; exit 3"}})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("got error %v, want an ExitError", err)
+	}
+	if exitErr.ExitCode != 3 || string(exitErr.Stderr) != "failed\n" {
+		t.Errorf("unexpected exit error %+v", exitErr)
+	}
+	if string(result.Stdout) != "out\n" {
+		t.Errorf("output not captured on failure: %q", result.Stdout)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r := &Exec{Logf: nopLogf}
+	if _, err := r.Run(ctx, Cmd{Name: "sleep", Args: []string{"10"}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command not killed on timeout, ran for %v", elapsed)
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := &Fake{
+		Results: map[string]FakeResult{
+			"minikube status":                   {ExitCode: 7, Result: Result{Stderr: []byte("host: Stopped")}},
+			"minikube service prometheus --url": {Result: Result{Stdout: []byte("http://192.168.39.2:30900\n")}},
+		},
+	}
+
+	_, err := f.Run(context.Background(), Cmd{Name: "minikube", Args: []string{"status"}})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 7 {
+		t.Errorf("got error %v, want exit status 7", err)
+	}
+
+	result, err := f.Run(context.Background(), Cmd{Name: "minikube", Args: []string{"service", "prometheus", "--url"}})
+	if err != nil || string(result.Stdout) != "http://192.168.39.2:30900\n" {
+		t.Errorf("got %q, %v", result.Stdout, err)
+	}
+
+	if _, err := f.Run(context.Background(), Cmd{Name: "kubectl", Args: []string{"apply"}}); err != nil {
+		t.Errorf("unknown command failed: %v", err)
+	}
+
+	calls := f.Calls()
+	if len(calls) != 3 || calls[2].String() != "kubectl apply" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
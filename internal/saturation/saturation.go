@@ -0,0 +1,57 @@
+// Package saturation searches for the highest request rate a workload sustains.
+package saturation
+
+import "fmt"
+
+// Check returns an error if Search can't search between min and max with
+// precision.
+func Check(min, max int, precision float64) error {
+	switch {
+	case min < 1:
+		return fmt.Errorf("the lowest rate must be at least 1, got %d", min)
+	case max < min:
+		return fmt.Errorf("the highest rate %d is below the lowest one %d", max, min)
+	case precision <= 0:
+		return fmt.Errorf("the precision must be positive, got %g", precision)
+	}
+	return nil
+}
+
+// Search looks for the highest rate between min and max for which sustainable
+// returns true. The rate doubles from min until it isn't sustainable or max is
+// reached, then a binary search narrows down the rates between the highest
+// sustainable and the lowest unsustainable rates until they are within
+// precision of each other, relative to the sustainable one, or consecutive.
+//
+// Search returns the highest sustainable rate, 0 if min isn't sustainable, and
+// the lowest unsustainable rate, 0 if max is sustainable. It returns an error
+// if Check rejects min, max and precision.
+func Search(min, max int, precision float64, sustainable func(qps int) bool) (low, high int, err error) {
+	if err := Check(min, max, precision); err != nil {
+		return 0, 0, err
+	}
+
+	for qps := min; ; qps *= 2 {
+		if qps > max {
+			qps = max
+		}
+		if !sustainable(qps) {
+			high = qps
+			break
+		}
+		low = qps
+		if qps == max {
+			break
+		}
+	}
+
+	for low > 0 && high-low > 1 && float64(high-low) > precision*float64(low) {
+		qps := (low + high) / 2
+		if sustainable(qps) {
+			low = qps
+		} else {
+			high = qps
+		}
+	}
+	return low, high, nil
+}
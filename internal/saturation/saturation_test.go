@@ -0,0 +1,98 @@
+package saturation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		min, max  int
+		precision float64
+		low, high int
+		tried     []int
+	}{
+		{
+			name:  "bisect",
+			limit: 1000,
+			min:   100, max: 20000, precision: 0.05,
+			low: 1000, high: 1050,
+			tried: []int{100, 200, 400, 800, 1600, 1200, 1000, 1100, 1050},
+		},
+		{
+			name:  "min unsustainable",
+			limit: 50,
+			min:   100, max: 20000, precision: 0.05,
+			low: 0, high: 100,
+			tried: []int{100},
+		},
+		{
+			name:  "max sustainable",
+			limit: 1000,
+			min:   100, max: 500, precision: 0.05,
+			low: 500, high: 0,
+			tried: []int{100, 200, 400, 500},
+		},
+		{
+			name:  "unsustainable past max",
+			limit: 450,
+			min:   100, max: 500, precision: 0.1,
+			low: 450, high: 475,
+			tried: []int{100, 200, 400, 500, 450, 475},
+		},
+		{
+			name:  "consecutive rates",
+			limit: 2,
+			min:   1, max: 10, precision: 0.01,
+			low: 2, high: 3,
+			tried: []int{1, 2, 4, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tried []int
+			low, high, err := Search(test.min, test.max, test.precision, func(qps int) bool {
+				tried = append(tried, qps)
+				return qps <= test.limit
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if low != test.low || high != test.high {
+				t.Errorf("got low=%d high=%d, want low=%d high=%d", low, high, test.low, test.high)
+			}
+			if !reflect.DeepEqual(tried, test.tried) {
+				t.Errorf("tried %v, want %v", tried, test.tried)
+			}
+		})
+	}
+}
+
+func TestSearchInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		min, max  int
+		precision float64
+	}{
+		{name: "zero min", min: 0, max: 100, precision: 0.05},
+		{name: "negative min", min: -1, max: 100, precision: 0.05},
+		{name: "max below min", min: 100, max: 50, precision: 0.05},
+		{name: "zero precision", min: 100, max: 200, precision: 0},
+		{name: "negative precision", min: 100, max: 200, precision: -0.05},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Search(test.min, test.max, test.precision, func(qps int) bool {
+				t.Fatalf("tried %d qps", qps)
+				return true
+			})
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
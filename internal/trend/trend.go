@@ -0,0 +1,107 @@
+// Package trend fits linear trends to metric samples and tells whether they
+// grow significantly, e.g. to find memory leaks during soak runs.
+package trend
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Sample is the value of a metric at a point in time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Fit is the least squares line fitted to samples.
+type Fit struct {
+	Samples int `json:"samples"`
+	// Mean is the mean of the samples.
+	Mean float64 `json:"mean"`
+	// SlopePerHour is the growth of the value per hour.
+	SlopePerHour float64 `json:"slope_per_hour"`
+	// StdErrPerHour is the standard error of SlopePerHour.
+	StdErrPerHour float64 `json:"stderr_per_hour"`
+	// PValue is the probability of fitting a slope at least as high if the
+	// value didn't grow. It uses the normal approximation of the t
+	// distribution and assumes independent errors, so it is optimistic for
+	// few or strongly autocorrelated samples.
+	PValue float64 `json:"p_value"`
+}
+
+// MinSamples is the number of samples needed to fit a trend.
+const MinSamples = 10
+
+// ErrTooFewSamples is returned when there are less than MinSamples samples
+// or they were all taken at the same time.
+var ErrTooFewSamples = errors.New("not enough samples to fit a trend")
+
+// LeastSquares fits a line to samples.
+func LeastSquares(samples []Sample) (Fit, error) {
+	n := len(samples)
+	if n < MinSamples {
+		return Fit{}, ErrTooFewSamples
+	}
+
+	// Hours since the first sample, to keep the values small.
+	origin := samples[0].Time
+	var sumX, sumY float64
+	for _, s := range samples {
+		sumX += s.Time.Sub(origin).Hours()
+		sumY += s.Value
+	}
+	meanX, meanY := sumX/float64(n), sumY/float64(n)
+
+	var sxx, sxy float64
+	for _, s := range samples {
+		dx := s.Time.Sub(origin).Hours() - meanX
+		sxx += dx * dx
+		sxy += dx * (s.Value - meanY)
+	}
+	if sxx == 0 {
+		return Fit{}, ErrTooFewSamples
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	var sse float64
+	for _, s := range samples {
+		r := s.Value - (intercept + slope*s.Time.Sub(origin).Hours())
+		sse += r * r
+	}
+	stderr := math.Sqrt(sse / float64(n-2) / sxx)
+
+	fit := Fit{
+		Samples:       n,
+		Mean:          meanY,
+		SlopePerHour:  slope,
+		StdErrPerHour: stderr,
+	}
+	switch {
+	case stderr > 0:
+		fit.PValue = 0.5 * math.Erfc(slope/stderr/math.Sqrt2)
+	case slope > 0:
+		// A perfect line.
+		fit.PValue = 0
+	default:
+		fit.PValue = 1
+	}
+	return fit, nil
+}
+
+// RelativeSlopePerHour returns the growth per hour relative to the mean of
+// the samples, e.g. 0.05 for 5% per hour.
+func (f Fit) RelativeSlopePerHour() float64 {
+	if f.Mean == 0 {
+		return 0
+	}
+	return f.SlopePerHour / math.Abs(f.Mean)
+}
+
+// Growing tells whether the fitted value grows significantly, that is with a
+// p-value of at most maxPValue and by at least minRelativeGrowth of its mean
+// per hour, so that negligible but steady growth isn't reported.
+func (f Fit) Growing(maxPValue, minRelativeGrowth float64) bool {
+	return f.SlopePerHour > 0 && f.PValue <= maxPValue && f.RelativeSlopePerHour() >= minRelativeGrowth
+}
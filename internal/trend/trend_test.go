@@ -0,0 +1,82 @@
+package trend
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// samples returns hourly samples of base + slope*h with uniform noise of the
+// given amplitude.
+func samples(hours int, base, slope, noise float64) []Sample {
+	r := rand.New(rand.NewSource(1))
+	start := time.Unix(1600000000, 0)
+	var s []Sample
+	for i := 0; i <= hours*60; i += 5 {
+		h := float64(i) / 60
+		s = append(s, Sample{
+			Time:  start.Add(time.Duration(i) * time.Minute),
+			Value: base + slope*h + noise*(2*r.Float64()-1),
+		})
+	}
+	return s
+}
+
+func TestLeastSquares(t *testing.T) {
+	fit, err := LeastSquares(samples(6, 200e6, 10e6, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(fit.SlopePerHour-10e6) > 1 || fit.PValue != 0 {
+		t.Errorf("unexpected fit of a line %+v", fit)
+	}
+	if math.Abs(fit.RelativeSlopePerHour()-10e6/230e6) > 1e-9 {
+		t.Errorf("got relative slope %v", fit.RelativeSlopePerHour())
+	}
+}
+
+func TestGrowing(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Sample
+		growing bool
+	}{
+		{
+			name:    "leak",
+			samples: samples(4, 200e6, 5e6, 2e6),
+			growing: true,
+		},
+		{
+			name:    "flat with noise",
+			samples: samples(4, 200e6, 0, 20e6),
+		},
+		{
+			name:    "shrinking",
+			samples: samples(4, 200e6, -5e6, 2e6),
+		},
+		{
+			name:    "negligible growth",
+			samples: samples(4, 200e6, 100e3, 10e3),
+		},
+	}
+	for _, tt := range tests {
+		fit, err := LeastSquares(tt.samples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fit.Growing(0.01, 0.01); got != tt.growing {
+			t.Errorf("%s: got growing %v, want %v (%+v)", tt.name, got, tt.growing, fit)
+		}
+	}
+}
+
+func TestTooFewSamples(t *testing.T) {
+	if _, err := LeastSquares(samples(0, 1, 1, 0)); err != ErrTooFewSamples {
+		t.Errorf("got error %v, want %v", err, ErrTooFewSamples)
+	}
+	same := make([]Sample, MinSamples)
+	if _, err := LeastSquares(same); err != ErrTooFewSamples {
+		t.Errorf("got error %v for samples taken at the same time, want %v", err, ErrTooFewSamples)
+	}
+}
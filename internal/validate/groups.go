@@ -0,0 +1,115 @@
+package validate
+
+import (
+	"path/filepath"
+
+	"github.com/cilium/cilium-perf-test/internal/versions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// nativeCIDR is substituted in the gke Cilium manifest when the tests run in
+// the test cluster.
+var nativeCIDR = Placeholder{
+	ConfigMap: "cilium-config",
+	Key:       "native-routing-cidr",
+	Token:     "NATIVE_CIDR_PLACEHOLDER",
+	By:        "gke/run_in_test_cluster.sh",
+}
+
+// Group is a set of manifests deployed together.
+type Group struct {
+	Name    string
+	Targets []Target
+}
+
+// Layout locates the manifests of the repository and the namespaces the gke
+// tests deploy them in.
+type Layout struct {
+	// ManifestPath holds the per Cilium version manifests.
+	ManifestPath string
+	// SharedManifestPath holds the Cilium version agnostic manifests.
+	SharedManifestPath string
+	// CiliumNamespace is the namespace the gke tests deploy Cilium in.
+	CiliumNamespace string
+	// MonitoringNamespace is the namespace the gke tests deploy the
+	// monitoring stack in.
+	MonitoringNamespace string
+}
+
+// Groups returns the manifests of every Cilium version of the registry and the
+// shared manifests, grouped the way the gke and minikube tests deploy them.
+func (l Layout) Groups() ([]Group, error) {
+	var groups []Group
+	for _, name := range versions.Names() {
+		v, err := versions.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, l.versionGroups(v)...)
+	}
+	shared, err := l.sharedGroups()
+	if err != nil {
+		return nil, err
+	}
+	return append(groups, shared...), nil
+}
+
+// versionGroups returns the manifests of the Cilium version v deployed
+// together by the gke and minikube tests.
+func (l Layout) versionGroups(v *versions.Version) []Group {
+	monitoring := v.Manifest(l.ManifestPath, v.MonitoringManifest)
+	var groups []Group
+
+	if manifest, err := v.CiliumManifest(l.ManifestPath, "gke"); err == nil {
+		groups = append(groups, Group{
+			Name: "gke " + v.Name,
+			Targets: []Target{
+				{Path: manifest, Applier: Tests(l.CiliumNamespace), Placeholders: []Placeholder{nativeCIDR}},
+				{Path: monitoring, Applier: Tests(l.MonitoringNamespace)},
+				{Path: filepath.Join(l.SharedManifestPath, "node-stats.yaml"), Applier: Tests(l.MonitoringNamespace)},
+			},
+		})
+		// exposePrometheus only updates the Prometheus Service of the
+		// monitoring manifest.
+		expose := Tests(l.MonitoringNamespace)
+		expose.Kinds = []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Service")}
+		groups = append(groups, Group{
+			Name:    "gke " + v.Name + " Prometheus",
+			Targets: []Target{{Path: v.Manifest(l.ManifestPath, v.ExposePrometheusManifest), Applier: expose}},
+		})
+	}
+
+	if manifest, err := v.CiliumManifest(l.ManifestPath, "minikube"); err == nil {
+		groups = append(groups, Group{
+			Name: "minikube " + v.Name,
+			Targets: []Target{
+				{Path: manifest, Applier: Kubectl},
+				{Path: monitoring, Applier: Kubectl},
+			},
+		})
+	}
+	return groups
+}
+
+// sharedGroups returns the shared manifests, deployed in the namespace of each
+// test case. The test cases are defined in the tests, each shared manifest is
+// validated on its own.
+func (l Layout) sharedGroups() ([]Group, error) {
+	manifests, err := filepath.Glob(filepath.Join(l.SharedManifestPath, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	for _, manifest := range manifests {
+		// node-stats.yaml is deployed with the monitoring stack.
+		if filepath.Base(manifest) == "node-stats.yaml" {
+			continue
+		}
+		groups = append(groups, Group{
+			Name:    "shared " + filepath.Base(manifest),
+			Targets: []Target{{Path: manifest, Applier: Tests(TestNamespace)}},
+		})
+	}
+	return groups, nil
+}
@@ -0,0 +1,39 @@
+package validate
+
+import (
+	"testing"
+)
+
+// TestRepository validates the manifests of the repository like
+// cmd/validate does with its default flags.
+func TestRepository(t *testing.T) {
+	s, err := LoadSchema("schemas/kubernetes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := Layout{
+		ManifestPath:        "../../manifests",
+		SharedManifestPath:  "../../shared",
+		CiliumNamespace:     "cilium-perf",
+		MonitoringNamespace: "cilium-monitoring",
+	}.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) == 0 {
+		t.Fatal("no manifests found")
+	}
+
+	validator := New(s)
+	for _, g := range groups {
+		findings, err := validator.Validate(g.Targets...)
+		if err != nil {
+			t.Fatalf("error validating %s: %s", g.Name, err)
+		}
+		for _, f := range findings {
+			if f.Severity == Error {
+				t.Errorf("%s: %s", g.Name, f)
+			}
+		}
+	}
+}
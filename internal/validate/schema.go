@@ -0,0 +1,198 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+
+	openapi_v2 "github.com/googleapis/gnostic/OpenAPIv2"
+	"github.com/googleapis/gnostic/compiler"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/util/proto"
+	"k8s.io/kube-openapi/pkg/util/proto/validation"
+)
+
+// Schema holds the OpenAPI schemas of Kubernetes objects.
+type Schema struct {
+	models proto.Models
+	// definitions are the names of the definitions of the kinds.
+	definitions map[schema.GroupVersionKind]string
+}
+
+// openAPI holds the fields of a Kubernetes OpenAPI v2 document that Trim
+// keeps.
+type openAPI struct {
+	Swagger     string                     `json:"swagger"`
+	Info        json.RawMessage            `json:"info"`
+	Paths       map[string]json.RawMessage `json:"paths"`
+	Definitions map[string]json.RawMessage `json:"definitions"`
+}
+
+// definitionKinds returns the kinds of the definitions of doc, by definition
+// name.
+func definitionKinds(doc *openAPI) (map[string][]schema.GroupVersionKind, error) {
+	kinds := make(map[string][]schema.GroupVersionKind)
+	for name, raw := range doc.Definitions {
+		// The extension lists the kinds the definition is the schema of.
+		var def struct {
+			GVKs []schema.GroupVersionKind `json:"x-kubernetes-group-version-kind"`
+		}
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("failed to decode definition %s: %w", name, err)
+		}
+		kinds[name] = def.GVKs
+	}
+	return kinds, nil
+}
+
+// LoadSchema reads the Kubernetes OpenAPI v2 document path, e.g. a schema
+// bundled with the repository.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	s, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseSchema parses a Kubernetes OpenAPI v2 document, e.g. the output of
+// kubectl get --raw /openapi/v2.
+func ParseSchema(data []byte) (*Schema, error) {
+	var doc openAPI
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	kinds, err := definitionKinds(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := compiler.ReadInfoFromBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	document, err := openapi_v2.NewDocument(info, compiler.NewContext("$root", nil))
+	if err != nil {
+		return nil, err
+	}
+	models, err := proto.NewOpenAPIData(document)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{models: models, definitions: make(map[schema.GroupVersionKind]string)}
+	for name, gvks := range kinds {
+		for _, gvk := range gvks {
+			s.definitions[gvk] = name
+		}
+	}
+	return s, nil
+}
+
+// Has tells whether s has the schema of the kind gvk.
+func (s *Schema) Has(gvk schema.GroupVersionKind) bool {
+	_, ok := s.definitions[gvk]
+	return ok
+}
+
+// Validate validates obj, decoded from JSON, against the schema of its kind
+// gvk. Unknown fields are errors. It returns no error for the kinds s has no
+// schema of.
+func (s *Schema) Validate(gvk schema.GroupVersionKind, obj map[string]interface{}) []error {
+	name, ok := s.definitions[gvk]
+	if !ok {
+		return nil
+	}
+	model := s.models.LookupModel(name)
+	if model == nil {
+		return []error{fmt.Errorf("schema of %s not found", name)}
+	}
+	return validation.ValidateModel(obj, model, gvk.Kind)
+}
+
+// refPattern matches the references to the other definitions of a definition.
+var refPattern = regexp.MustCompile(`"\$ref":\s*"#/definitions/([^"]+)"`)
+
+// Trim returns the Kubernetes OpenAPI v2 document data with only the
+// definitions of kinds and the ones they reference, without their paths and
+// descriptions, to keep the bundled schema small.
+func Trim(data []byte, kinds []schema.GroupVersionKind) ([]byte, error) {
+	var doc openAPI
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	defKinds, err := definitionKinds(&doc)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[schema.GroupVersionKind]bool)
+	for _, gvk := range kinds {
+		wanted[gvk] = true
+	}
+
+	var queue []string
+	for name, gvks := range defKinds {
+		for _, gvk := range gvks {
+			if wanted[gvk] {
+				queue = append(queue, name)
+				break
+			}
+		}
+	}
+	sort.Strings(queue)
+
+	keep := make(map[string]json.RawMessage)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := keep[name]; ok {
+			continue
+		}
+		raw, ok := doc.Definitions[name]
+		if !ok {
+			return nil, fmt.Errorf("definition %s not found", name)
+		}
+		var def interface{}
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("failed to decode definition %s: %w", name, err)
+		}
+		stripDescriptions(def)
+		if keep[name], err = json.Marshal(def); err != nil {
+			return nil, err
+		}
+		for _, m := range refPattern.FindAllSubmatch(raw, -1) {
+			queue = append(queue, string(m[1]))
+		}
+	}
+
+	return json.MarshalIndent(openAPI{
+		Swagger:     doc.Swagger,
+		Info:        doc.Info,
+		Paths:       map[string]json.RawMessage{},
+		Definitions: keep,
+	}, "", "  ")
+}
+
+// stripDescriptions removes the descriptions of the JSON value v, in place.
+func stripDescriptions(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// The description of a property named description is a schema.
+		if _, ok := v["description"].(string); ok {
+			delete(v, "description")
+		}
+		for _, value := range v {
+			stripDescriptions(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			stripDescriptions(value)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// Package validate checks the manifests the tests deploy without a cluster:
+// each document is decoded like the tests do and validated against the
+// OpenAPI schema of its kind, and the objects are checked for unpinned images,
+// kinds the tests can't deploy, namespace conflicts and placeholders.
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cilium/cilium-perf-test/internal/images"
+	"github.com/cilium/cilium-perf-test/internal/yamldoc"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// SupportedKinds are the kinds of objects deployManifest of the gke tests
+// deploys. It must be kept in sync with it.
+var SupportedKinds = []schema.GroupVersionKind{
+	{Group: "", Version: "v1", Kind: "ConfigMap"},
+	{Group: "", Version: "v1", Kind: "Namespace"},
+	{Group: "", Version: "v1", Kind: "Service"},
+	{Group: "", Version: "v1", Kind: "ServiceAccount"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"},
+	{Group: "cilium.io", Version: "v2", Kind: "CiliumNetworkPolicy"},
+}
+
+// clusterScoped are the kinds of the cluster-scoped objects the manifests may
+// hold.
+var clusterScoped = map[string]bool{
+	"ClusterRole":        true,
+	"ClusterRoleBinding": true,
+	"Namespace":          true,
+}
+
+// TestNamespace stands for the namespace the gke tests create for each test
+// case.
+const TestNamespace = "<test namespace>"
+
+// Applier describes how the tests apply a manifest.
+type Applier struct {
+	// Kinds are the kinds of objects the applier handles, any when nil.
+	Kinds []schema.GroupVersionKind
+	// Namespace is the namespace the applier deploys the namespaced objects
+	// in, whatever the one they declare, e.g. TestNamespace. When empty, they
+	// are deployed in the one they declare, "default" if none.
+	Namespace string
+}
+
+// Kubectl applies manifests like kubectl apply does.
+var Kubectl = Applier{}
+
+// Tests returns the applier of the gke tests deploying manifests in namespace.
+func Tests(namespace string) Applier {
+	return Applier{Kinds: SupportedKinds, Namespace: namespace}
+}
+
+// Placeholder is a token of a ConfigMap value substituted when a manifest is
+// deployed, e.g. by a script.
+type Placeholder struct {
+	// ConfigMap and Key locate the value holding the token.
+	ConfigMap string
+	Key       string
+	Token     string
+	// By tells what substitutes the token.
+	By string
+}
+
+// placeholderPattern matches placeholder tokens.
+var placeholderPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]*_PLACEHOLDER\b`)
+
+// Target is a manifest and the way it is deployed.
+type Target struct {
+	Path    string
+	Applier Applier
+	// Placeholders are the placeholders substituted in the manifest, the
+	// other tokens are left as they are.
+	Placeholders []Placeholder
+}
+
+// Severity is the severity of a finding.
+type Severity string
+
+const (
+	// Error findings break the deployment of the manifest.
+	Error Severity = "error"
+	// Warning findings may make runs differ from one another or from the
+	// intent of the manifest.
+	Warning Severity = "warning"
+)
+
+// Finding is a problem found in a manifest.
+type Finding struct {
+	Path string
+	// Doc is the index of the document of the manifest, from 1.
+	Doc int
+	// Object is the kind and name of the object, if decoded.
+	Object   string
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	s := fmt.Sprintf("%s: %s: document %d", f.Severity, f.Path, f.Doc)
+	if f.Object != "" {
+		s += " (" + f.Object + ")"
+	}
+	return s + ": " + f.Message
+}
+
+// object is an object of a manifest.
+type object struct {
+	path string
+	doc  int
+	gvk  schema.GroupVersionKind
+	name string
+	// declared is the namespace declared by the object.
+	declared string
+	// namespace is the namespace the object is deployed in, "" if it is
+	// cluster-scoped.
+	namespace string
+	obj       runtime.Object
+}
+
+func (o *object) String() string {
+	return o.gvk.Kind + " " + o.name
+}
+
+// key identifies o in the cluster.
+func (o *object) key() string {
+	return strings.Join([]string{o.gvk.Group, o.gvk.Kind, o.namespace, o.name}, "/")
+}
+
+// Validator validates manifests.
+type Validator struct {
+	schema *Schema
+}
+
+// New returns a Validator validating the objects against schema.
+func New(schema *Schema) *Validator {
+	return &Validator{schema: schema}
+}
+
+// Validate validates the manifests of targets, deployed together, and returns
+// its findings sorted by manifest. It only fails if a manifest can't be read.
+func (v *Validator) Validate(targets ...Target) ([]Finding, error) {
+	var findings []Finding
+	var objects []*object
+	for _, target := range targets {
+		data, err := ioutil.ReadFile(target.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		f, objs := v.manifest(target, data)
+		findings = append(findings, f...)
+		objects = append(objects, objs...)
+	}
+	findings = append(findings, conflicts(objects)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Doc < findings[j].Doc
+	})
+	return findings, nil
+}
+
+// manifest validates the documents of the manifest data deployed as target
+// and returns the findings and the objects decoded.
+func (v *Validator) manifest(target Target, data []byte) ([]Finding, []*object) {
+	var findings []Finding
+	var objects []*object
+	docs, readErr := yamldoc.Read(bytes.NewReader(data))
+	n := 0
+	for _, doc := range docs {
+		n++
+		add := func(o *object, severity Severity, format string, args ...interface{}) {
+			f := Finding{Path: target.Path, Doc: n, Severity: severity, Message: fmt.Sprintf(format, args...)}
+			if o != nil {
+				f.Object = o.String()
+			}
+			findings = append(findings, f)
+		}
+
+		for _, token := range placeholderPattern.FindAll(doc, -1) {
+			if !substituted(target.Placeholders, string(token)) {
+				add(nil, Error, "%s is never substituted", token)
+			}
+		}
+
+		o, err := v.decode(target, doc)
+		if err != nil {
+			add(o, Error, "%s", err)
+			continue
+		}
+		o.path, o.doc = target.Path, n
+		objects = append(objects, o)
+
+		if !handled(target.Applier.Kinds, o.gvk) {
+			add(o, Error, "%s is not handled by the tests", o.gvk)
+		}
+		// The schemas of the Cilium custom resources aren't bundled.
+		if !v.schema.Has(o.gvk) && o.gvk.Group != "cilium.io" {
+			add(o, Warning, "no schema to validate %s against", o.gvk)
+		}
+		if _, err := sigsyaml.YAMLToJSONStrict(doc); err != nil {
+			var lines []string
+			for _, line := range strings.Split(strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:"), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					lines = append(lines, line)
+				}
+			}
+			add(o, Warning, "%s, the last value is used", strings.Join(lines, "; "))
+		}
+		if o.obj != nil {
+			for _, image := range podImages(o.obj) {
+				if !pinned(image) {
+					add(o, Warning, "image %s is not pinned to a tag or digest", image)
+				}
+			}
+		}
+		if ns := target.Applier.Namespace; ns != "" && o.namespace != "" && o.declared != "" && o.declared != ns {
+			add(o, Warning, "declares namespace %s but is deployed in %s", o.declared, ns)
+		}
+		for _, msg := range subjectConflicts(target, o) {
+			add(o, Error, "%s", msg)
+		}
+		for _, msg := range placeholderValues(target.Placeholders, o) {
+			add(o, Warning, "%s", msg)
+		}
+	}
+	if readErr != nil {
+		findings = append(findings, Finding{Path: target.Path, Doc: n + 1, Severity: Error, Message: readErr.Error()})
+	}
+	return findings, objects
+}
+
+// decode decodes doc into an object and validates it against its schema.
+func (v *Validator) decode(target Target, doc []byte) (*object, error) {
+	data, err := sigsyaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("failed to decode: not an object")
+	}
+	var u struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+	gv, err := schema.ParseGroupVersion(u.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+	if u.Kind == "" {
+		return nil, fmt.Errorf("failed to decode: kind not set")
+	}
+	o := &object{gvk: gv.WithKind(u.Kind), name: u.Metadata.Name, declared: u.Metadata.Namespace}
+	if o.name == "" {
+		return o, fmt.Errorf("name not set")
+	}
+
+	// Like the tests, decode the objects known to client-go with the
+	// UniversalDeserializer.
+	o.obj, _, err = scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		o.obj, err = nil, nil
+	}
+	if err != nil {
+		return o, fmt.Errorf("failed to decode: %w", err)
+	}
+
+	if errs := v.schema.Validate(o.gvk, obj); len(errs) > 0 {
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return o, fmt.Errorf("invalid %s: %s", o.gvk.Kind, strings.Join(msgs, "; "))
+	}
+
+	switch {
+	case clusterScoped[o.gvk.Kind]:
+	case target.Applier.Namespace != "":
+		o.namespace = target.Applier.Namespace
+	case o.declared != "":
+		o.namespace = o.declared
+	default:
+		o.namespace = "default"
+	}
+	return o, nil
+}
+
+// handled tells whether gvk is one of kinds, nil standing for all of them.
+func handled(kinds []schema.GroupVersionKind, gvk schema.GroupVersionKind) bool {
+	if kinds == nil {
+		return true
+	}
+	for _, kind := range kinds {
+		if kind == gvk {
+			return true
+		}
+	}
+	return false
+}
+
+// podImages returns the images of the containers of the pod template of obj.
+func podImages(obj runtime.Object) []string {
+	spec := images.PodSpecOf(obj)
+	if spec == nil {
+		return nil
+	}
+	var list []string
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, c := range containers {
+			list = append(list, c.Image)
+		}
+	}
+	return list
+}
+
+// pinned tells whether image has a digest or a tag other than latest.
+func pinned(image string) bool {
+	if strings.Contains(image, "@") {
+		return true
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	colon := strings.LastIndex(name, ":")
+	return colon >= 0 && name[colon+1:] != "latest"
+}
+
+// subjectConflicts returns the ServiceAccounts the binding o binds in another
+// namespace than the one the applier deploys them in. The tests don't rewrite
+// the namespaces of the subjects.
+func subjectConflicts(target Target, o *object) []string {
+	namespace := target.Applier.Namespace
+	if namespace == "" {
+		return nil
+	}
+	var msgs []string
+	var subjects []rbacv1.Subject
+	switch b := o.obj.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		subjects = b.Subjects
+	case *rbacv1.RoleBinding:
+		subjects = b.Subjects
+	}
+	for _, s := range subjects {
+		if s.Kind == rbacv1.ServiceAccountKind && s.Namespace != namespace {
+			msgs = append(msgs, fmt.Sprintf("binds ServiceAccount %s in namespace %s but ServiceAccounts are deployed in %s", s.Name, s.Namespace, namespace))
+		}
+	}
+	return msgs
+}
+
+// conflicts returns the objects deployed twice with the same name.
+func conflicts(objects []*object) []Finding {
+	var findings []Finding
+	seen := make(map[string]*object)
+	for _, o := range objects {
+		first, ok := seen[o.key()]
+		if !ok {
+			seen[o.key()] = o
+			continue
+		}
+		where := "cluster"
+		if o.namespace != "" {
+			where = "namespace " + o.namespace
+		}
+		findings = append(findings, Finding{
+			Path:     o.path,
+			Doc:      o.doc,
+			Object:   o.String(),
+			Severity: Error,
+			Message:  fmt.Sprintf("conflicts in the %s with document %d of %s", where, first.doc, first.path),
+		})
+	}
+	return findings
+}
+
+// substituted tells whether token is one of placeholders.
+func substituted(placeholders []Placeholder, token string) bool {
+	for _, p := range placeholders {
+		if p.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholderValues returns the values of the ConfigMap o set to something
+// other than the placeholder substituted in them.
+func placeholderValues(placeholders []Placeholder, o *object) []string {
+	cm, ok := o.obj.(*corev1.ConfigMap)
+	if !ok {
+		return nil
+	}
+	var msgs []string
+	for _, p := range placeholders {
+		if cm.Name != p.ConfigMap {
+			continue
+		}
+		if value, ok := cm.Data[p.Key]; ok && value != p.Token {
+			msgs = append(msgs, fmt.Sprintf("%s is hardcoded to %q, %s substitutes %s in it", p.Key, value, p.By, p.Token))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,205 @@
+package validate
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestPinned(t *testing.T) {
+	tests := map[string]bool{
+		"busybox":                                    false,
+		"busybox:latest":                             false,
+		"localhost:5000/cilium/cilium":               false,
+		"busybox:1.32":                               true,
+		"localhost:5000/cilium/cilium:dev":           true,
+		"gcr.io/google-samples/frontend@sha256:0123": true,
+	}
+	for image, want := range tests {
+		if got := pinned(image); got != want {
+			t.Errorf("pinned(%q) = %v, want %v", image, got, want)
+		}
+	}
+}
+
+const manifest = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: agent
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: agent
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: agent
+subjects:
+- kind: ServiceAccount
+  name: agent
+  namespace: kube-system
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  cidr: 10.0.0.0/8
+  token: UNKNOWN_PLACEHOLDER
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replica: 2
+  selector:
+    matchLabels:
+      app: app
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        image: glibsm/abchain
+---
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: agent
+`
+
+func TestManifest(t *testing.T) {
+	s, err := LoadSchema("schemas/kubernetes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Target{
+		Path:         "test.yaml",
+		Applier:      Tests("cilium"),
+		Placeholders: []Placeholder{{ConfigMap: "config", Key: "cidr", Token: "CIDR_PLACEHOLDER", By: "test"}},
+	}
+	findings, objects := New(s).manifest(target, []byte(manifest))
+	findings = append(findings, conflicts(objects)...)
+
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	sort.Strings(got)
+	want := []string{
+		`error: test.yaml: document 2 (ClusterRoleBinding agent): binds ServiceAccount agent in namespace kube-system but ServiceAccounts are deployed in cilium`,
+		`error: test.yaml: document 3: UNKNOWN_PLACEHOLDER is never substituted`,
+		`error: test.yaml: document 4 (Deployment app): invalid Deployment: ValidationError(Deployment.spec): unknown field "replica" in io.k8s.api.apps.v1.DeploymentSpec`,
+		`error: test.yaml: document 5 (ReplicaSet app): apps/v1, Kind=ReplicaSet is not handled by the tests`,
+		`error: test.yaml: document 6 (ServiceAccount agent): conflicts in the namespace cilium with document 1 of test.yaml`,
+		`warning: test.yaml: document 1 (ServiceAccount agent): declares namespace kube-system but is deployed in cilium`,
+		`warning: test.yaml: document 3 (ConfigMap config): cidr is hardcoded to "10.0.0.0/8", test substitutes CIDR_PLACEHOLDER in it`,
+		`warning: test.yaml: document 5 (ReplicaSet app): no schema to validate apps/v1, Kind=ReplicaSet against`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestManifestKubectl(t *testing.T) {
+	s, err := LoadSchema("schemas/kubernetes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, objects := New(s).manifest(Target{Path: "test.yaml", Applier: Kubectl}, []byte(manifest))
+
+	var got []string
+	for _, f := range findings {
+		if strings.Contains(f.Message, "not handled") || strings.Contains(f.Message, "deployed in") {
+			got = append(got, f.String())
+		}
+	}
+	if len(got) > 0 {
+		t.Errorf("unexpected findings with kubectl:\n%s", strings.Join(got, "\n"))
+	}
+	if len(conflicts(objects)) > 0 {
+		t.Error("ServiceAccounts of different namespaces conflict with kubectl")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	doc := `{
+  "swagger": "2.0",
+  "info": {"title": "Kubernetes", "version": "v1.13.0"},
+  "paths": {"/api/v1/namespaces": {}},
+  "definitions": {
+    "io.k8s.api.core.v1.ConfigMap": {
+      "description": "ConfigMap holds configuration data.",
+      "properties": {
+        "data": {"type": "object", "additionalProperties": {"type": "string"}},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "properties": {
+        "description": {"type": "string", "description": "Not a description."},
+        "name": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.Secret": {
+      "properties": {"metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}},
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Secret", "version": "v1"}]
+    }
+  }
+}`
+	trimmed, err := Trim([]byte(doc), []schema.GroupVersionKind{{Version: "v1", Kind: "ConfigMap"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got openAPI
+	if err := json.Unmarshal(trimmed, &got); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range got.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{"io.k8s.api.core.v1.ConfigMap", "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got definitions %v, want %v", names, want)
+	}
+	if len(got.Paths) > 0 {
+		t.Errorf("paths not trimmed: %v", got.Paths)
+	}
+	if strings.Contains(string(trimmed), "description\": \"") {
+		t.Errorf("descriptions not trimmed:\n%s", trimmed)
+	}
+	if !strings.Contains(string(trimmed), "\"description\": {") {
+		t.Errorf("description property trimmed:\n%s", trimmed)
+	}
+
+	s, err := ParseSchema(trimmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	if !s.Has(gvk) {
+		t.Fatal("ConfigMap schema missing")
+	}
+	obj := map[string]interface{}{"data": map[string]interface{}{"a": "b"}, "immutable": true}
+	if errs := s.Validate(gvk, obj); len(errs) != 1 || !strings.Contains(errs[0].Error(), `unknown field "immutable"`) {
+		t.Errorf("got errors %v", errs)
+	}
+}
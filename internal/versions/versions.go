@@ -0,0 +1,173 @@
+// Package versions is the registry of the Cilium versions the perf tests can
+// run against. Each entry tells the drivers where the rendered manifests of the
+// version are and what differs from one version to the next, so that
+// supporting a new release means adding an entry rather than forking a driver.
+package versions
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+)
+
+// Version describes how to deploy and observe a Cilium minor version.
+type Version struct {
+	// Name is the Cilium minor version, e.g. "1.8".
+	Name string
+	// Dir is the directory holding the manifests of the version, relative to
+	// the manifests root.
+	Dir string
+	// CiliumManifests are the rendered Cilium manifests, by provider, e.g.
+	// "gke" or "minikube".
+	CiliumManifests map[string]string
+	// MonitoringManifest deploys Prometheus and Grafana to the
+	// cilium-monitoring namespace.
+	MonitoringManifest string
+	// ExposePrometheusManifest holds the Prometheus Service exposed outside of
+	// the cluster.
+	ExposePrometheusManifest string
+	// Monitoring describes the labels of the series scraped by the Prometheus
+	// server of MonitoringManifest.
+	Monitoring metrics.Monitoring
+	// Metrics defines the logical metrics for the version.
+	Metrics    metrics.Set
+	ConfigKeys ConfigKeys
+	Quirks     Quirks
+}
+
+// ConfigKeys are the keys of the cilium-config ConfigMap for the options set
+// by the tests.
+type ConfigKeys struct {
+	// PProf enables the pprof endpoint of the agents.
+	PProf string
+}
+
+// Quirks are the deployment particularities of a version.
+type Quirks struct {
+	// RestartMetricsServer is set when metrics-server must be restarted once
+	// Cilium is deployed on GKE so that its pod is managed by Cilium.
+	RestartMetricsServer bool
+}
+
+var registry = map[string]*Version{
+	"1.8": {
+		Name: "1.8",
+		Dir:  "1.8",
+		CiliumManifests: map[string]string{
+			"gke":      "cilium-hubble-metrics-gke-de838c984dfd.yaml",
+			"minikube": "cilium-hubble-metrics-d4415c6fc.yaml",
+		},
+		MonitoringManifest:       "cilium-monitoring-263ebed.yaml",
+		ExposePrometheusManifest: "expose-prometheus.yaml",
+		Monitoring: metrics.Monitoring{
+			// The kubernetes-pods job of the monitoring manifest turns the
+			// pod labels into series labels.
+			Agent:          []string{`k8s_app="cilium"`},
+			Operator:       []string{`io_cilium_app="operator"`},
+			TargetPodLabel: "kubernetes_pod_name",
+			ContainerLabel: "container",
+			PodLabel:       "pod",
+			NamespaceLabel: "namespace",
+			// The kubernetes-cadvisor job turns the node labels into series
+			// labels.
+			NodeLabel: "kubernetes_io_hostname",
+		},
+		Metrics: metrics18,
+		ConfigKeys: ConfigKeys{
+			PProf: "pprof",
+		},
+		Quirks: Quirks{
+			RestartMetricsServer: true,
+		},
+	},
+}
+
+var metrics18 = metrics.Set{
+	metrics.AgentCPU:            metrics.Rate("cilium_process_cpu_seconds_total"),
+	metrics.AgentResidentMemory: metrics.Gauge("cilium_process_resident_memory_bytes"),
+	metrics.AgentVirtualMemory:  metrics.Gauge("cilium_process_virtual_memory_bytes"),
+	metrics.AgentBootstrap:      metrics.Gauge("cilium_agent_bootstrap_seconds", `scope="overall"`),
+	// The agent registers the Go collector without a namespace.
+	metrics.AgentGoHeap:             metrics.Gauge("go_memstats_heap_inuse_bytes"),
+	metrics.AgentGoroutines:         metrics.Gauge("go_goroutines"),
+	metrics.AgentOpenFDs:            metrics.Gauge("cilium_process_open_fds"),
+	metrics.BPFMapsMemory:           metrics.Gauge("cilium_bpf_maps_virtual_memory_max_bytes"),
+	metrics.BPFProgsMemory:          metrics.Gauge("cilium_bpf_progs_virtual_memory_max_bytes"),
+	metrics.EndpointRegenerationP99: metrics.Quantile(0.99, "cilium_endpoint_regeneration_time_stats_seconds", `scope="total"`),
+	metrics.PolicyRegenerationP99:   metrics.Quantile(0.99, "cilium_policy_regeneration_time_stats_seconds", `scope="total"`),
+	metrics.PolicyRegenerations:     metrics.Rate("cilium_policy_regeneration_total"),
+	// The counter has no _total suffix before 1.9.
+	metrics.EndpointRegenerations: {
+		Query: metrics.Rate("cilium_endpoint_regenerations").Query,
+		By:    "outcome",
+	},
+	metrics.Endpoints: {
+		Query: metrics.Gauge("cilium_endpoint_state").Query,
+		By:    "endpoint_state",
+	},
+	metrics.IPAddresses: {
+		Query: metrics.Gauge("cilium_ip_addresses").Query,
+		By:    "family",
+	},
+	metrics.ServicesAdded:          metrics.Gauge("cilium_services_events_total", `action="add"`),
+	metrics.ProxyUpstreamReplyP99:  metrics.Quantile(0.99, "cilium_proxy_upstream_reply_seconds"),
+	metrics.OperatorCPU:            metrics.OperatorRate("cilium_operator_process_cpu_seconds_total"),
+	metrics.OperatorResidentMemory: metrics.OperatorGauge("cilium_operator_process_resident_memory_bytes"),
+	// The operator registers the Go collector without a namespace.
+	metrics.OperatorGoroutines: metrics.OperatorGauge("go_goroutines"),
+	metrics.OperatorIdentityGCEntries: {
+		Query: metrics.OperatorGauge("cilium_operator_identity_gc_entries").Query,
+		By:    "status",
+	},
+	metrics.OperatorIdentityGCRuns: {
+		Query: metrics.OperatorRate("cilium_operator_identity_gc_runs").Query,
+		By:    "outcome",
+	},
+	metrics.AgentContainerCPU: {
+		Query: func(m metrics.Monitoring, window string) string {
+			return fmt.Sprintf("rate(container_cpu_usage_seconds_total%s[%s])", m.ContainerSelector("cilium-agent"), window)
+		},
+	},
+	metrics.AgentContainerMemory: {
+		Query: func(m metrics.Monitoring, _ string) string {
+			return "container_memory_working_set_bytes" + m.ContainerSelector("cilium-agent")
+		},
+	},
+}
+
+// Get returns the registry entry of the Cilium version name.
+func Get(name string) (*Version, error) {
+	v, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown Cilium version %q, known versions are %v", name, Names())
+	}
+	return v, nil
+}
+
+// Names returns the versions of the registry, sorted.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Manifest returns the path of the manifest name of the version, root being
+// the manifests root directory.
+func (v *Version) Manifest(root, name string) string {
+	return path.Join(root, v.Dir, name)
+}
+
+// CiliumManifest returns the path of the Cilium manifest of the version for
+// provider, root being the manifests root directory.
+func (v *Version) CiliumManifest(root, provider string) (string, error) {
+	name, ok := v.CiliumManifests[provider]
+	if !ok {
+		return "", fmt.Errorf("no Cilium %s manifest for %s", v.Name, provider)
+	}
+	return v.Manifest(root, name), nil
+}
@@ -0,0 +1,41 @@
+package versions
+
+import (
+	"os"
+	"testing"
+)
+
+// manifestsRoot is the manifests root directory, relative to this package.
+const manifestsRoot = "../../manifests"
+
+func TestManifestsExist(t *testing.T) {
+	for _, name := range Names() {
+		v, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifests := []string{
+			v.Manifest(manifestsRoot, v.MonitoringManifest),
+			v.Manifest(manifestsRoot, v.ExposePrometheusManifest),
+		}
+		for provider := range v.CiliumManifests {
+			m, err := v.CiliumManifest(manifestsRoot, provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifests = append(manifests, m)
+		}
+		for _, m := range manifests {
+			if _, err := os.Stat(m); err != nil {
+				t.Errorf("manifest of Cilium %s: %s", name, err)
+			}
+		}
+	}
+}
+
+func TestGetUnknownVersion(t *testing.T) {
+	if _, err := Get("0.1"); err == nil {
+		t.Error("getting an unknown version didn't fail")
+	}
+}
@@ -0,0 +1,34 @@
+// Package yamldoc splits YAML manifests into their documents the way kubectl
+// does, so that every tool of the repository reads the same objects from a
+// manifest.
+package yamldoc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Read returns the YAML documents of manifest, leaving out the ones without
+// content, e.g. license headers made of comments only. On error, it returns
+// the documents read until then.
+func Read(manifest io.Reader) ([][]byte, error) {
+	var docs [][]byte
+	r := yaml.NewYAMLReader(bufio.NewReader(manifest))
+	for {
+		doc, err := r.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return docs, fmt.Errorf("failed to read manifest: %w", err)
+		}
+		if data, err := sigsyaml.YAMLToJSON(doc); err == nil && string(data) == "null" {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+}
@@ -0,0 +1,35 @@
+package yamldoc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	manifest := `# ----
+# header
+# ----
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: a
+---
+---
+# only a comment
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: b
+`
+	docs, err := Read(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents, want 2", len(docs))
+	}
+	if !strings.Contains(string(docs[1]), "name: b") {
+		t.Errorf("second document is %q", docs[1])
+	}
+}
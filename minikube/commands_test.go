@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cilium/cilium-perf-test/internal/images"
+	"github.com/cilium/cilium-perf-test/internal/run"
+	"github.com/cilium/cilium-perf-test/internal/versions"
+)
+
+// commandLines returns the command lines run by f.
+func commandLines(f *run.Fake) []string {
+	var lines []string
+	for _, cmd := range f.Calls() {
+		lines = append(lines, cmd.String())
+	}
+	return lines
+}
+
+func TestCreateMinikube(t *testing.T) {
+	f := &run.Fake{
+		Results: map[string]run.FakeResult{
+			"minikube status": {ExitCode: 7},
+		},
+	}
+	createMinikube(t, f)
+
+	want := []string{"minikube status", "minikube start --network-plugin=cni"}
+	if got := commandLines(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+}
+
+func TestApplyManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minikube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "abchain.yaml")
+	if err := ioutil.WriteFile(manifest, []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: abchain
+spec:
+  template:
+    spec:
+      containers:
+      - name: abchain
+        image: glibsm/abchain:0.0.2
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &run.Fake{}
+	if err := applyManifest(f, manifest, nil); err != nil {
+		t.Fatal(err)
+	}
+	mapping := &images.Mapping{Registry: "localhost:5000"}
+	if err := applyManifest(f, manifest, mapping); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := f.Calls()
+	want := []string{"kubectl apply -f " + manifest, "kubectl apply -f -"}
+	if got := commandLines(f); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got commands %q, want %q", got, want)
+	}
+	stdin, err := ioutil.ReadAll(calls[1].Stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stdin), "image: localhost:5000/glibsm/abchain:0.0.2") {
+		t.Errorf("image not rewritten in applied manifest:\n%s", stdin)
+	}
+
+	f = &run.Fake{
+		Results: map[string]run.FakeResult{
+			"kubectl apply -f " + manifest: {ExitCode: 1},
+		},
+	}
+	if err := applyManifest(f, manifest, nil); err == nil {
+		t.Error("kubectl failure not returned")
+	}
+}
+
+func TestExposePrometheus(t *testing.T) {
+	version, err := versions.Get("1.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &run.Fake{}
+	exposePrometheus(t, f, version)
+
+	want := []string{"kubectl apply -f " + version.Manifest(manifestPath, version.ExposePrometheusManifest)}
+	if got := commandLines(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+}
+
+func TestGetPrometheusURL(t *testing.T) {
+	f := &run.Fake{
+		Results: map[string]run.FakeResult{
+			"minikube service prometheus --url -n cilium-monitoring": {
+				Result: run.Result{Stdout: []byte("http://192.168.39.2:30900\n")},
+			},
+		},
+	}
+	if got, want := getPrometheusURL(t, f), "http://192.168.39.2:30900"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
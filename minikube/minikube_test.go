@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium-perf-test/internal/images"
+	"github.com/cilium/cilium-perf-test/internal/metrics"
+	"github.com/cilium/cilium-perf-test/internal/readiness"
+	"github.com/cilium/cilium-perf-test/internal/run"
+	"github.com/cilium/cilium-perf-test/internal/versions"
+	kt "github.com/dlespiau/kube-test-harness"
+	"github.com/dlespiau/kube-test-harness/logger"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	ciliumVersionName string
+	manifestPath      string
+	imageMapPath      string
+	prePull           bool
+)
+
+func init() {
+	flag.StringVar(&ciliumVersionName, "cilium-version", "1.8", fmt.Sprintf("Cilium version to test, one of %v", versions.Names()))
+	flag.StringVar(&manifestPath, "manifest-path", "../manifests", "path that the per Cilium version manifests are in")
+	flag.StringVar(&imageMapPath, "image-map", "", "YAML file mapping the images of the manifests to the ones to deploy, e.g. from a local registry")
+	flag.BoolVar(&prePull, "prepull", false, "pull the images of the manifests before deploying them")
+}
+
+// Baseline overhead of running cilium with hubble enabled.
+func TestBaseline(t *testing.T) {
+	version, err := versions.Get(ciliumVersionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mapping *images.Mapping
+	if imageMapPath != "" {
+		if mapping, err = images.Load(imageMapPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := run.Default
+	createMinikube(t, r)
+	defer deleteMinikube(t, r)
+
+	h := kt.New(kt.Options{
+		LogLevel: logger.Debug,
+	})
+	if err := h.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	if prePull {
+		prePullImages(t, h.KubeClient(), version, mapping)
+	}
+	deployCilium(t, r, h.KubeClient(), version, mapping)
+	deployMonitoring(t, r, h.KubeClient(), version, mapping)
+	exposePrometheus(t, r, version)
+
+	runTime := 7 * time.Minute
+	log.Printf("Letting the cluster run for %v to gather metrics...", runTime)
+	<-time.After(runTime)
+	queryCPUMetrics(t, getPrometheusURL(t, r), version, 5*time.Minute)
+}
+
+// command runs the command name with args with r, copying its output to the
+// standard output and error.
+func command(r run.Runner, name string, args ...string) error {
+	_, err := r.Run(context.Background(), run.Cmd{Name: name, Args: args, Tee: true})
+	return err
+}
+
+func createMinikube(t *testing.T, r run.Runner) {
+	if isMinikubeRunning(t, r) {
+		t.Fatal("minikube is already running. Delete it and let the test set it up")
+	}
+
+	startCNIMinikube(t, r)
+}
+
+func deleteMinikube(t *testing.T, r run.Runner) {
+	t.Log("Deleting minikube")
+	if err := command(r,
+		"minikube",
+		"delete",
+	); err != nil {
+		t.Fatalf("failed to delete minikube: %v", err)
+	}
+}
+
+// applyManifest applies manifest with kubectl run by r, after rewriting its
+// images according to mapping.
+func applyManifest(r run.Runner, manifest string, mapping *images.Mapping) error {
+	if mapping == nil {
+		return command(r, "kubectl", "apply", "-f", manifest)
+	}
+
+	f, err := os.Open(manifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rewritten, err := mapping.Manifest(f)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite images of %s: %w", manifest, err)
+	}
+	_, err = r.Run(context.Background(), run.Cmd{
+		Name:  "kubectl",
+		Args:  []string{"apply", "-f", "-"},
+		Stdin: bytes.NewReader(rewritten),
+		Tee:   true,
+	})
+	return err
+}
+
+func deployCilium(t *testing.T, r run.Runner, client kubernetes.Interface, version *versions.Version, mapping *images.Mapping) {
+	manifest, err := version.CiliumManifest(manifestPath, "minikube")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deploy cilium kitchen sink. testing library doesn't support this kind of
+	// an arbitrary file deploy as far as I can tell. it tried to force manifests
+	// into specific namespaces.
+	if err := applyManifest(r, manifest, mapping); err != nil {
+		t.Fatalf("failed to apply cilium manifest: %v", err)
+	}
+
+	// ui usually takes about ~60s so give it some room
+	waitForManifest(t, client, manifest, 3*time.Minute)
+}
+
+func deployMonitoring(t *testing.T, r run.Runner, client kubernetes.Interface, version *versions.Version, mapping *images.Mapping) {
+	manifest := version.Manifest(manifestPath, version.MonitoringManifest)
+	if err := applyManifest(r, manifest, mapping); err != nil {
+		t.Fatalf("failed to deploy cilium monitoring: %v", err)
+	}
+
+	waitForManifest(t, client, manifest, 3*time.Minute)
+}
+
+// waitForManifest waits for the workloads applied from manifest to be ready.
+func waitForManifest(t *testing.T, client kubernetes.Interface, manifest string, timeout time.Duration) {
+	f, err := os.Open(manifest)
+	if err != nil {
+		t.Fatalf("failed to open manifest %q: %s", manifest, err)
+	}
+	defer f.Close()
+
+	// kubectl applies the objects without a namespace to the default one.
+	workloads, err := readiness.Manifest(f, "default")
+	if err != nil {
+		t.Fatalf("failed to read manifest %q: %s", manifest, err)
+	}
+	if err := readiness.Wait(client, workloads, timeout); err != nil {
+		t.Fatal("error waiting for workloads: ", err)
+	}
+}
+
+// prePullImages pulls the images of the Cilium and monitoring manifests on the
+// minikube node before they are deployed.
+func prePullImages(t *testing.T, client kubernetes.Interface, version *versions.Version, mapping *images.Mapping) {
+	ciliumManifest, err := version.CiliumManifest(manifestPath, "minikube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	for _, manifest := range []string{ciliumManifest, version.Manifest(manifestPath, version.MonitoringManifest)} {
+		f, err := os.Open(manifest)
+		if err != nil {
+			t.Fatalf("failed to open manifest %q: %s", manifest, err)
+		}
+		l, err := images.List(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("failed to read manifest %q: %s", manifest, err)
+		}
+		for _, image := range l {
+			list = append(list, mapping.Rewrite(image))
+		}
+	}
+
+	var pullPolicy corev1.PullPolicy
+	if mapping != nil {
+		pullPolicy = mapping.PullPolicy
+	}
+	ds := images.PrePullDaemonSet("image-prepull", list, pullPolicy)
+	daemonSets := client.AppsV1().DaemonSets("default")
+	if _, err := daemonSets.Create(context.TODO(), ds, metav1.CreateOptions{}); err != nil {
+		t.Fatal("error creating the pre-pull DaemonSet: ", err)
+	}
+	defer daemonSets.Delete(context.TODO(), ds.Name, metav1.DeleteOptions{})
+
+	log.Printf("Pre-pulling %d images...", len(list))
+	if err := images.WaitPrePull(client, "default", ds.Name, 15*time.Minute); err != nil {
+		t.Fatal("error pre-pulling images: ", err)
+	}
+}
+
+func exposePrometheus(t *testing.T, r run.Runner, version *versions.Version) {
+	if err := command(r,
+		"kubectl",
+		"apply", "-f",
+		version.Manifest(manifestPath, version.ExposePrometheusManifest),
+	); err != nil {
+		t.Fatalf("failed to deploy cilium monitoring: %v", err)
+	}
+}
+
+// queryCPUMetrics prints the highest agent CPU usage, in cores, over the last
+// duration, resolved through the metrics of version.
+func queryCPUMetrics(t *testing.T, base string, version *versions.Version, duration time.Duration) {
+	q, err := version.Metrics.Aggregate("max", metrics.AgentCPU, version.Monitoring, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := api.NewClient(api.Config{
+		Address: base,
+	})
+	if err != nil {
+		t.Fatal("error creating prometheus client", err)
+	}
+
+	v1api := v1.NewAPI(client)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r := v1.Range{
+		Start: time.Now().Add(-duration),
+		End:   time.Now(),
+		Step:  time.Minute,
+	}
+	result, _, err := v1api.QueryRange(ctx, q, r)
+	if err != nil {
+		t.Fatal("error querying Prometheus", err)
+	}
+	fmt.Printf("%s (%s):\n%v\n", metrics.AgentCPU, q, result)
+}
+
+func getPrometheusURL(t *testing.T, r run.Runner) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	result, err := r.Run(ctx, run.Cmd{
+		Name: "minikube",
+		Args: []string{"service", "prometheus", "--url", "-n", "cilium-monitoring"},
+	})
+	if err != nil {
+		t.Fatal("failed to get prometheus url", err)
+	}
+
+	return strings.TrimSpace(string(result.Stdout))
+}
+
+func isMinikubeRunning(t *testing.T, r run.Runner) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := r.Run(ctx, run.Cmd{Name: "minikube", Args: []string{"status"}})
+	return err == nil
+}
+
+func startCNIMinikube(t *testing.T, r run.Runner) {
+	t.Log("Starting minikube")
+	if err := command(r,
+		"minikube",
+		"start",
+		"--network-plugin=cni",
+	); err != nil {
+		t.Fatalf("failed to start minikube: %v", err)
+	}
+}